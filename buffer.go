@@ -7,9 +7,74 @@ The `Lexer` is however an improved version of `Shifter`, allowing zero-copy for
 */
 package buffer // import "github.com/tdewolff/buffer"
 
+import (
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+// ErrExceeded is returned when a lookahead limit or MaxBuf has been exceeded.
+var ErrExceeded = errors.New("max buffer exceeded")
+
+// ErrOverflow is returned when a varint overflows a 64-bit integer.
+var ErrOverflow = errors.New("varint overflows a 64-bit integer")
+
+// ErrSeek is returned when seeking to a position that is not in memory.
+var ErrSeek = errors.New("seek position not in memory")
+
+// ErrQuote is returned by Shifter.ScanField for a quote in an unquoted field or for bytes after the closing quote of a quoted field, like csv.ErrQuote.
+var ErrQuote = errors.New("extraneous or missing quote in field")
+
 // defaultBufSize specifies the default initial length of internal buffers.
 var defaultBufSize = 4096
 
+// GrowthFactor specifies the factor by which internal buffers grow when they need to be reallocated.
+// A smaller factor reduces peak memory usage at the cost of more reallocations and copying. Factors below 1 are treated as 1, so that buffers never shrink.
+var GrowthFactor = 2.0
+
+func grow(c int) int {
+	if n := int(GrowthFactor * float64(c)); c < n {
+		return n
+	}
+	return c
+}
+
 // MinBuf specifies the default initial length of internal buffers.
 // Solely here to support old versions of parse.
 var MinBuf = defaultBufSize
+
+// MaxBuf specifies the maximum length of the internal buffers of Lexer, a token plus lookahead that needs more returns ErrExceeded. This protects against running out of memory on a single huge token in untrusted input.
+// It also limits the initial length of internal buffers when sized from the length of the io.Reader.
+var MaxBuf = 64 << 20
+
+// sizeHint returns the initial buffer size for an io.Reader. If it implements Len, such as bytes.Reader and strings.Reader, the size is one more than the remaining length so that EOF is detected without reallocating, clamped to MaxBuf. Otherwise it returns the default of 4kB.
+func sizeHint(r io.Reader) int {
+	if lr, ok := r.(interface {
+		Len() int
+	}); ok {
+		if n := lr.Len(); n < MaxBuf {
+			return n + 1
+		}
+		return MaxBuf
+	}
+	return defaultBufSize
+}
+
+// decodeRune decodes the rune at the ith byte using peek, like utf8.DecodeRune, and returns the rune and its byte length. Invalid UTF-8 returns utf8.RuneError with length 1. It only peeks as many bytes as the first byte requires.
+func decodeRune(peek func(int) byte, i int) (rune, int) {
+	c := peek(i)
+	if c < utf8.RuneSelf {
+		return rune(c), 1
+	}
+	n := 4
+	if c < 0xE0 {
+		n = 2
+	} else if c < 0xF0 {
+		n = 3
+	}
+	b := [4]byte{c}
+	for j := 1; j < n; j++ {
+		b[j] = peek(i + j)
+	}
+	return utf8.DecodeRune(b[:n])
+}
@@ -0,0 +1,46 @@
+package buffer // import "github.com/tdewolff/buffer"
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/tdewolff/test"
+)
+
+func TestDecodeRune(t *testing.T) {
+	var runeTests = []string{"a", "æ", "†", "\U00100000", "\x80", "\xc3", "\xc3(", "\xe2\x82", "\xed\xa0\x80", "\xf4\x90\x80\x80", "\xff", "\x00"}
+	for _, s := range runeTests {
+		b := []byte(s)
+		peek := func(i int) byte {
+			if i < len(b) {
+				return b[i]
+			}
+			return 0
+		}
+		r, n := decodeRune(peek, 0)
+		rExpected, nExpected := utf8.DecodeRune(b)
+		test.That(t, r == rExpected && n == nExpected, "rune must match utf8.DecodeRune for", s)
+	}
+}
+
+func FuzzPeekRune(f *testing.F) {
+	for _, s := range []string{"a", "æ", "†", "\U00100000", "\xc3(", "\xf4\x90\x80\x80"} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if len(b) == 0 {
+			return
+		}
+		rExpected, nExpected := utf8.DecodeRune(b)
+		if r, n := NewShifter(test.NewPlainReader(bytes.NewBuffer(b))).PeekRune(0); r != rExpected || n != nExpected {
+			t.Fatalf("Shifter.PeekRune(%q) = %q, %d, want %q, %d", b, r, n, rExpected, nExpected)
+		}
+		if r, n := NewLexer(test.NewPlainReader(bytes.NewBuffer(b))).PeekRune(0); r != rExpected || n != nExpected {
+			t.Fatalf("Lexer.PeekRune(%q) = %q, %d, want %q, %d", b, r, n, rExpected, nExpected)
+		}
+		if r, n := NewMemLexerBytes(append([]byte{}, b...)).PeekRune(0); r != rExpected || n != nExpected {
+			t.Fatalf("MemLexer.PeekRune(%q) = %q, %d, want %q, %d", b, r, n, rExpected, nExpected)
+		}
+	})
+}
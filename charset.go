@@ -0,0 +1,65 @@
+package buffer // import "github.com/tdewolff/buffer"
+
+// Charset is a set of bytes for fast membership tests, useful for defining character classes in lexers such as for Shifter.AcceptRunTable.
+// Its methods take a value receiver so that charsets can be combined in package-level declarations, and the Shifter methods take a *Charset to avoid copying it.
+type Charset [256]bool
+
+// MakeCharset returns a Charset containing the given bytes.
+func MakeCharset(bytes string) Charset {
+	cs := Charset{}
+	for i := 0; i < len(bytes); i++ {
+		cs[bytes[i]] = true
+	}
+	return cs
+}
+
+// RangeCharset returns a Charset containing the bytes from lo to hi inclusive.
+func RangeCharset(lo, hi byte) Charset {
+	cs := Charset{}
+	for c := int(lo); c <= int(hi); c++ {
+		cs[c] = true
+	}
+	return cs
+}
+
+// Contains returns true if c is in the Charset.
+func (cs Charset) Contains(c byte) bool {
+	return cs[c]
+}
+
+// Or returns the union of the Charset and other.
+func (cs Charset) Or(other Charset) Charset {
+	for c, ok := range other {
+		if ok {
+			cs[c] = true
+		}
+	}
+	return cs
+}
+
+var (
+	spaceCharset  = MakeCharset(" \t\n\v\f\r")
+	digitCharset  = RangeCharset('0', '9')
+	letterCharset = RangeCharset('a', 'z').Or(RangeCharset('A', 'Z'))
+	hexCharset    = digitCharset.Or(RangeCharset('a', 'f')).Or(RangeCharset('A', 'F'))
+)
+
+// IsSpace returns true if c is an ASCII whitespace character, ie. space, \t, \n, \v, \f or \r.
+func IsSpace(c byte) bool {
+	return spaceCharset[c]
+}
+
+// IsDigit returns true if c is an ASCII decimal digit.
+func IsDigit(c byte) bool {
+	return digitCharset[c]
+}
+
+// IsLetter returns true if c is an ASCII letter.
+func IsLetter(c byte) bool {
+	return letterCharset[c]
+}
+
+// IsHex returns true if c is an ASCII hexadecimal digit.
+func IsHex(c byte) bool {
+	return hexCharset[c]
+}
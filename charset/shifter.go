@@ -0,0 +1,17 @@
+// Package charset decodes input in other character encodings for use with the buffer package.
+// It is a separate package so that the buffer package does not depend on golang.org/x/text.
+package charset // import "github.com/tdewolff/buffer/charset"
+
+import (
+	"io"
+
+	"github.com/tdewolff/buffer"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// NewShifter returns a new buffer.Shifter for a given io.Reader with a 4kB estimated buffer size, which decodes the input from the given character encoding to UTF-8.
+// Decoding errors are returned by Err.
+func NewShifter(r io.Reader, enc encoding.Encoding) *buffer.Shifter {
+	return buffer.NewShifter(transform.NewReader(r, enc.NewDecoder()))
+}
@@ -0,0 +1,24 @@
+package charset // import "github.com/tdewolff/buffer/charset"
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/tdewolff/test"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestShifter(t *testing.T) {
+	z := NewShifter(bytes.NewBufferString("caf\xe9 \xc6\xf8"), charmap.ISO8859_1)
+	r, n := z.PeekRune(3)
+	test.That(t, r == 'é' && n == 2, "fourth rune must be 'é'")
+	r, n = z.PeekRune(6)
+	test.That(t, r == 'Æ' && n == 2, "sixth rune must be 'Æ'")
+	r, n = z.PeekRune(8)
+	test.That(t, r == 'ø' && n == 2, "seventh rune must be 'ø'")
+	z.Move(10)
+	test.Bytes(t, z.Shift(), []byte("café Æø"), "input must be decoded to UTF-8")
+	test.That(t, z.Peek(0) == 0, "must yield EOF at the end")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+}
@@ -0,0 +1,70 @@
+package buffer // import "github.com/tdewolff/buffer"
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestCharset(t *testing.T) {
+	cs := MakeCharset("_-")
+	test.That(t, cs.Contains('_') && cs.Contains('-'), "charset must contain its bytes")
+	test.That(t, !cs.Contains('a') && !cs.Contains(0), "charset must not contain other bytes")
+
+	digits := RangeCharset('0', '9')
+	test.That(t, digits.Contains('0') && digits.Contains('5') && digits.Contains('9'), "range must be inclusive")
+	test.That(t, !digits.Contains('/') && !digits.Contains(':'), "range must not contain bytes outside")
+	full := RangeCharset(0, 255)
+	test.That(t, full.Contains(0) && full.Contains(255), "full range must contain all bytes")
+
+	ident := RangeCharset('a', 'z').Or(RangeCharset('A', 'Z')).Or(digits).Or(MakeCharset("_"))
+	test.That(t, ident.Contains('q') && ident.Contains('Q') && ident.Contains('7') && ident.Contains('_'), "union must contain all charsets")
+	test.That(t, !ident.Contains('-'), "union must not contain other bytes")
+	test.That(t, !digits.Contains('a'), "union must not modify the operands")
+
+	z := NewShifter(bytes.NewBufferString("lorem_42-ipsum"))
+	test.That(t, z.AcceptRunTable(&ident) == 8, "charset must be usable as a lookup table")
+}
+
+func TestClassifiers(t *testing.T) {
+	for c := 0; c < 256; c++ {
+		b := byte(c)
+		test.That(t, IsSpace(b) == (b == ' ' || '\t' <= b && b <= '\r'), "IsSpace must match for", c)
+		test.That(t, IsDigit(b) == ('0' <= b && b <= '9'), "IsDigit must match for", c)
+		test.That(t, IsLetter(b) == ('a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'), "IsLetter must match for", c)
+		test.That(t, IsHex(b) == ('0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'), "IsHex must match for", c)
+	}
+	test.That(t, !IsSpace(0x85) && !IsSpace(0xA0), "IsSpace must be ASCII-only")
+	test.That(t, !IsLetter('@') && !IsLetter('[') && !IsLetter('`') && !IsLetter('{'), "IsLetter boundaries must not match")
+	test.That(t, !IsDigit('/') && !IsDigit(':'), "IsDigit boundaries must not match")
+	test.That(t, !IsHex('g') && !IsHex('G'), "IsHex boundaries must not match")
+}
+
+////////////////////////////////////////////////////////////////
+
+var _classifierInput = []byte("Lorem ipsum 42, dolor sit 0xBEEF amet.\n")
+
+func BenchmarkIsHexTable(b *testing.B) {
+	n := 0
+	for i := 0; i < b.N; i++ {
+		for _, c := range _classifierInput {
+			if IsHex(c) {
+				n++
+			}
+		}
+	}
+	_c += n
+}
+
+func BenchmarkIsHexInline(b *testing.B) {
+	n := 0
+	for i := 0; i < b.N; i++ {
+		for _, c := range _classifierInput {
+			if '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F' {
+				n++
+			}
+		}
+	}
+	_c += n
+}
@@ -0,0 +1,16 @@
+package buffer // import "github.com/tdewolff/buffer"
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// NewGzipReader returns an io.Reader that decompresses the gzip stream of r on demand, which can be wrapped by NewShifter or NewLexer to lex compressed input without decompressing it up front.
+// It returns an error when the gzip header is invalid, errors in the compressed data are returned by Read.
+func NewGzipReader(r io.Reader) (io.Reader, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr, nil
+}
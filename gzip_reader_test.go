@@ -0,0 +1,53 @@
+package buffer // import "github.com/tdewolff/buffer"
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestGzipReader(t *testing.T) {
+	s := strings.Repeat("lorem ipsum dolor sit amet ", 500)
+	b := &bytes.Buffer{}
+	w := gzip.NewWriter(b)
+	w.Write([]byte(s))
+	w.Close()
+
+	r, err := NewGzipReader(b)
+	test.T(t, err, nil, "error")
+	z := NewShifter(r)
+	var tokens []string
+	for {
+		i := 0
+		for c := z.Peek(i); c != ' ' && c != 0; c = z.Peek(i) {
+			i++
+		}
+		if i == 0 {
+			break
+		}
+		z.Move(i)
+		tokens = append(tokens, string(z.Shift()))
+		z.Move(1)
+		z.Skip()
+	}
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+	test.T(t, strings.Join(tokens, " "), strings.Join(strings.Fields(s), " "), "tokens must match the uncompressed input")
+
+	_, err = NewGzipReader(bytes.NewBufferString("lorem ipsum"))
+	test.T(t, err, gzip.ErrHeader, "invalid header must be returned at construction")
+
+	w = gzip.NewWriter(b)
+	w.Write([]byte(s))
+	w.Close()
+	c := b.Bytes()
+	c[len(c)-8] ^= 0xFF // corrupt the checksum
+	r, err = NewGzipReader(b)
+	test.T(t, err, nil, "error")
+	z = NewShifter(r)
+	z.MoveToEnd()
+	test.T(t, z.Err(), gzip.ErrChecksum, "corrupt data must be returned by Err")
+}
@@ -1,6 +1,11 @@
 package buffer // import "github.com/tdewolff/buffer"
 
-import "io"
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
 
 type block struct {
 	buf    []byte
@@ -64,6 +69,16 @@ func (z *bufferPool) free(n int) {
 	}
 }
 
+// dump returns a human-readable description of the pool's blocks and pointers, which is useful for debugging. It does not modify the pool.
+func (z *bufferPool) dump() string {
+	sb := bytes.Buffer{}
+	fmt.Fprintf(&sb, "head=%d tail=%d pos=%d\n", z.head, z.tail, z.pos)
+	for i, b := range z.pool {
+		fmt.Fprintf(&sb, "%d: len=%d cap=%d active=%v next=%d\n", i+1, len(b.buf), cap(b.buf), b.active, b.next)
+	}
+	return sb.String()
+}
+
 // Lexer is a buffered reader that allows peeking forward and shifting, taking an io.Reader.
 // It keeps data in-memory until Free, taking a byte length, is called to move beyond the data.
 type Lexer struct {
@@ -80,10 +95,10 @@ type Lexer struct {
 	free int
 }
 
-// NewLexer returns a new Lexer for a given io.Reader with a 4kB estimated buffer size.
+// NewLexer returns a new Lexer for a given io.Reader with a 4kB estimated buffer size, or sized to the remaining length up to MaxBuf if the io.Reader implements Len.
 // If the io.Reader implements Bytes, that buffer is used instead.
 func NewLexer(r io.Reader) *Lexer {
-	return NewLexerSize(r, defaultBufSize)
+	return NewLexerSize(r, sizeHint(r))
 }
 
 // NewLexerSize returns a new Lexer for a given io.Reader and estimated required buffer size.
@@ -109,6 +124,22 @@ func (z *Lexer) read(pos int) byte {
 		return 0
 	}
 
+	// read into the spare capacity of the current buffer, which does not invalidate previously returned slices
+	// this avoids the buffer pool when the whole stream fits in the initial buffer
+	if pos < cap(z.buf) {
+		d := len(z.buf)
+		var n int
+		for pos >= d && z.err == nil {
+			n, z.err = z.r.Read(z.buf[d:cap(z.buf)])
+			d += n
+		}
+		z.buf = z.buf[:d]
+		if pos >= d {
+			return 0
+		}
+		return z.buf[pos]
+	}
+
 	// free unused bytes
 	z.pool.free(z.free)
 	z.free = 0
@@ -116,8 +147,14 @@ func (z *Lexer) read(pos int) byte {
 	// get new buffer
 	c := cap(z.buf)
 	p := pos - z.start + 1
-	if 2*p > c { // if the token is larger than half the buffer, increase buffer size
-		c = 2*c + p
+	if p > MaxBuf {
+		z.err = ErrExceeded
+		return 0
+	} else if 2*p > c { // if the token is larger than half the buffer, increase buffer size
+		c = grow(c) + p
+		if c > MaxBuf {
+			c = MaxBuf
+		}
 	}
 	d := len(z.buf) - z.start
 	buf := z.pool.swap(z.buf[:z.start], c)
@@ -131,6 +168,7 @@ func (z *Lexer) read(pos int) byte {
 	}
 	pos -= z.start
 	z.pos -= z.start
+	z.prevStart -= z.start
 	z.start, z.buf = 0, buf[:d]
 	if pos >= d {
 		return 0
@@ -139,6 +177,7 @@ func (z *Lexer) read(pos int) byte {
 }
 
 // Err returns the error returned from io.Reader. It may still return valid bytes for a while though.
+// The error is returned as is, so that io.EOF can be compared directly and other errors can be inspected with errors.Is and errors.As. Errors of the Lexer itself, such as ErrExceeded, are sentinels distinct from any error of io.Reader.
 func (z *Lexer) Err() error {
 	if z.err == io.EOF && z.pos < len(z.buf) {
 		return nil
@@ -152,8 +191,25 @@ func (z *Lexer) Free(n int) {
 	z.free += n
 }
 
+// FreeAll frees up all bytes of previously shifted tokens in one operation, so that Free need not be called after every Shift.
+// It resets ShiftLen.
+func (z *Lexer) FreeAll() {
+	z.pool.free(z.free + z.Pending())
+	z.free = 0
+	z.prevStart = z.start
+}
+
+// Pending returns the number of bytes of shifted tokens that have not been freed yet.
+func (z *Lexer) Pending() int {
+	n := z.start - z.pool.pos - z.free
+	for i := z.pool.tail; i != 0; i = z.pool.pool[i-1].next {
+		n += len(z.pool.pool[i-1].buf)
+	}
+	return n
+}
+
 // Peek returns the ith byte relative to the end position and possibly does an allocation.
-// Peek returns zero when an error has occurred, Err returns the error.
+// Peek returns zero when an error has occurred, Err returns the error. When the selection plus lookahead would need a buffer larger than MaxBuf, the error is ErrExceeded.
 // TODO: inline function
 func (z *Lexer) Peek(pos int) byte {
 	pos += z.pos
@@ -163,18 +219,25 @@ func (z *Lexer) Peek(pos int) byte {
 	return z.read(pos)
 }
 
-// PeekRune returns the rune and rune length of the ith byte relative to the end position.
+// PeekBytes returns the next n bytes after the end position, reading as needed, or fewer bytes when EOF is reached. The returned slice is valid until the next call to Peek that reads.
+func (z *Lexer) PeekBytes(n int) []byte {
+	if n <= 0 {
+		return z.buf[z.pos:z.pos]
+	}
+	z.Peek(n - 1)
+	end := z.pos + n
+	if end > len(z.buf) {
+		end = len(z.buf)
+	}
+	return z.buf[z.pos:end]
+}
+
+// PeekRune returns the rune and rune length of the ith byte relative to the end position. Invalid UTF-8 returns utf8.RuneError with length 1.
 func (z *Lexer) PeekRune(pos int) (rune, int) {
-	// from unicode/utf8
-	c := z.Peek(pos)
-	if c < 0xC0 {
+	if c := z.Peek(pos); c < utf8.RuneSelf {
 		return rune(c), 1
-	} else if c < 0xE0 {
-		return rune(c&0x1F)<<6 | rune(z.Peek(pos+1)&0x3F), 2
-	} else if c < 0xF0 {
-		return rune(c&0x0F)<<12 | rune(z.Peek(pos+1)&0x3F)<<6 | rune(z.Peek(pos+2)&0x3F), 3
 	}
-	return rune(c&0x07)<<18 | rune(z.Peek(pos+1)&0x3F)<<12 | rune(z.Peek(pos+2)&0x3F)<<6 | rune(z.Peek(pos+3)&0x3F), 4
+	return decodeRune(z.Peek, pos)
 }
 
 // Move advances the position.
@@ -183,20 +246,43 @@ func (z *Lexer) Move(n int) {
 }
 
 // Pos returns a mark to which can be rewinded.
+// The mark is relative to the start of the selection, so it remains valid when Peek reads into a new buffer or after Free, as long as the selection is not shifted or skipped.
+// Free only releases bytes of previously shifted tokens, which always lie before the selection, so it never releases the bytes of a valid mark.
 func (z *Lexer) Pos() int {
 	return z.pos - z.start
 }
 
-// Rewind rewinds the position to the given position.
+// Rewind rewinds the position to the given position, previously obtained from Pos.
+// It panics if pos lies before the start of the selection, since those bytes belong to shifted tokens and may have been freed.
 func (z *Lexer) Rewind(pos int) {
+	if pos < 0 {
+		panic("buffer: rewind before start of selection")
+	}
 	z.pos = z.start + pos
 }
 
+// ActiveBuffer returns the buffer that is currently being read into, which contains the bytes read so far from the start of the buffer. It is useful for inspecting what is buffered when debugging, together with DumpPool.
+// The returned slice must not be modified.
+func (z *Lexer) ActiveBuffer() []byte {
+	return z.buf
+}
+
+// DumpPool returns a human-readable description of the blocks and pointers of the buffer pool, which is useful for debugging. It does not modify the Lexer.
+func (z *Lexer) DumpPool() string {
+	return z.pool.dump()
+}
+
 // Lexeme returns the bytes of the current selection.
+// The returned slice aliases a pooled buffer, which is reused once its bytes have been passed to Free and Peek reads into a new buffer. Use BytesCopy to retain the bytes beyond that.
 func (z *Lexer) Lexeme() []byte {
 	return z.buf[z.start:z.pos]
 }
 
+// BytesCopy returns a copy of the bytes of the current selection, which remains valid regardless of Free and Peek.
+func (z *Lexer) BytesCopy() []byte {
+	return append([]byte{}, z.buf[z.start:z.pos]...)
+}
+
 // Skip collapses the position to the end of the selection.
 func (z *Lexer) Skip() {
 	z.start = z.pos
@@ -213,6 +299,13 @@ func (z *Lexer) Shift() []byte {
 	return b
 }
 
+// ShiftN is like Shift but also returns the number of bytes to pass to Free, which combines Shift and ShiftLen in one call.
+// The number equals the length of the selection unless Skip or Shift were called since the last call to ShiftLen or ShiftN. ShiftLen cannot be used in addition to ShiftN, since it was already reset.
+func (z *Lexer) ShiftN() ([]byte, int) {
+	b := z.Shift()
+	return b, z.ShiftLen()
+}
+
 // ShiftLen returns the number of bytes moved since the last call to ShiftLen. This can be used in calls to Free because it takes into account multiple Shifts or Skips.
 func (z *Lexer) ShiftLen() int {
 	n := z.start - z.prevStart
@@ -2,7 +2,10 @@ package buffer // import "github.com/tdewolff/buffer"
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"net"
+	"strings"
 	"testing"
 
 	"github.com/tdewolff/test"
@@ -45,6 +48,18 @@ func TestBufferPool(t *testing.T) {
 	test.That(t, cap(b) == len(consectetur)+1)
 }
 
+func TestBufferPoolDump(t *testing.T) {
+	z := &bufferPool{}
+	b := z.swap([]byte("Lorem ipsum"), 8)
+	b = append(b, "dolor"...)
+	z.swap(b, 16)
+	z.free(len("Lorem ipsum") + 2)
+
+	dump := z.dump()
+	test.T(t, dump, "head=2 tail=2 pos=2\n1: len=11 cap=11 active=false next=2\n2: len=5 cap=8 active=true next=0\n", "dump must reflect the pool")
+	test.T(t, z.dump(), dump, "dump must not modify the pool")
+}
+
 func TestLexer(t *testing.T) {
 	s := `Lorem ipsum dolor sit amet, consectetur adipiscing elit.`
 	z := NewLexer(bytes.NewBufferString(s))
@@ -90,6 +105,135 @@ func TestLexerShift(t *testing.T) {
 	test.That(t, z.ShiftLen() == len("Lorem "), "shifted length must equal last shift")
 }
 
+func TestLexerShiftLenAfterSwap(t *testing.T) {
+	s := `Lorem ipsum dolor sit amet, consectetur adipiscing elit.`
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString(s)), 8)
+	z.Move(6)
+	z.Shift()
+
+	test.That(t, z.Peek(10) == 'r', "must be 'r' at position 16")
+	z.Move(6)
+	test.Bytes(t, z.Shift(), []byte("ipsum "), "shift must span the new buffer")
+	test.That(t, z.ShiftLen() == len("Lorem ipsum "), "shifted length must include shifts before the buffer swap")
+	z.Move(6)
+	z.Shift()
+	test.That(t, z.ShiftLen() == len("dolor "), "shifted length must only include shifts since the previous call")
+}
+
+func TestLexerRewindAfterSwap(t *testing.T) {
+	s := `Lorem ipsum dolor sit amet, consectetur adipiscing elit.`
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString(s)), 8)
+	z.Move(6)
+	z.Free(len(z.Shift()))
+	z.Move(2)
+	mark := z.Pos()
+
+	test.That(t, z.Peek(20) == 'c', "must be 'c' at position 28")
+	z.Move(10)
+	test.Bytes(t, z.Lexeme(), []byte("ipsum dolor "), "selection must span the new buffer")
+	z.Rewind(mark)
+	test.That(t, z.Pos() == 2, "position must equal the mark")
+	test.Bytes(t, z.Lexeme(), []byte("ip"), "selection must match after rewinding to the mark")
+	test.That(t, z.Peek(0) == 's', "must be 's' after rewinding to the mark")
+}
+
+func TestLexerRewindAfterFree(t *testing.T) {
+	s := `Lorem ipsum dolor sit amet, consectetur adipiscing elit.`
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString(s)), 8)
+	z.Move(6)
+	_, n := z.ShiftN()
+	z.Move(6)
+	mark := z.Pos()
+	z.Free(n)
+	z.Peek(30)
+	z.Move(10)
+	test.That(t, z.Pending() == 0, "no bytes must be pending after freeing")
+	z.Rewind(mark)
+	test.Bytes(t, z.Lexeme(), []byte("ipsum "), "selection must match after rewinding to the mark")
+	test.That(t, z.Peek(0) == 'd', "must be 'd' after rewinding to the mark")
+
+	defer func() {
+		test.That(t, recover() != nil, "rewind before the selection must panic")
+	}()
+	z.Rewind(-1)
+}
+
+func TestLexerFreeAll(t *testing.T) {
+	s := bytes.Repeat([]byte("Lorem ipsum "), 10)
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBuffer(s)), 8)
+	n := 0
+	for c := z.Peek(0); c != 0; c = z.Peek(0) {
+		z.Move(1)
+		if c == ' ' {
+			n += len(z.Shift())
+		}
+	}
+	test.That(t, len(z.pool.pool) > 1, "tokens must span several buffers")
+	test.That(t, z.Pending() == n, "all shifted bytes must be pending")
+
+	z.FreeAll()
+	test.That(t, z.Pending() == 0, "no bytes must be pending after freeing all")
+	test.That(t, z.pool.tail == 0 && z.pool.head == 0, "pool must be empty after freeing all")
+	test.That(t, z.ShiftLen() == 0, "shift length must be reset")
+
+	z = NewLexerSize(test.NewPlainReader(bytes.NewBuffer(s)), 8)
+	z.Peek(12)
+	z.Move(6)
+	z.Free(len(z.Shift()))
+	z.Move(6)
+	z.Shift()
+	test.That(t, z.Pending() == 6, "freed bytes must not be pending")
+	z.FreeAll()
+	test.That(t, z.Pending() == 0, "no bytes must be pending after freeing all")
+	test.That(t, z.Peek(100) == 'm', "must be 'm' at position 112")
+}
+
+func TestLexerShiftN(t *testing.T) {
+	s := `Lorem ipsum dolor sit amet, consectetur adipiscing elit.`
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString(s)), 5)
+	for c := z.Peek(0); c != 0; c = z.Peek(0) {
+		z.Move(1)
+		if c == ' ' {
+			b, n := z.ShiftN()
+			test.That(t, n == len(b), "shifted length must equal the selection length for", string(b))
+			z.Free(n)
+		}
+	}
+	b, n := z.ShiftN()
+	test.Bytes(t, b, []byte("elit."), "last selection must be 'elit.'")
+	test.That(t, n == len(b), "shifted length must equal the selection length")
+
+	z = NewLexer(bytes.NewBufferString(s))
+	z.Move(6)
+	z.Skip()
+	z.Move(6)
+	b, n = z.ShiftN()
+	test.That(t, n == 12 && len(b) == 6, "shifted length must include skipped bytes")
+}
+
+func TestLexerPeekBytes(t *testing.T) {
+	s := `Lorem ipsum dolor`
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString(s)), 4)
+	test.Bytes(t, z.PeekBytes(3), []byte("Lor"), "must peek within the buffer")
+	z.Move(4)
+	z.Free(len(z.Shift()))
+	z.Move(2)
+	test.Bytes(t, z.PeekBytes(5), []byte("ipsum"), "must peek across a pool swap")
+	test.Bytes(t, z.Lexeme(), []byte("m "), "selection must be unaffected")
+	test.Bytes(t, z.PeekBytes(0), []byte{}, "must peek zero bytes")
+	test.Bytes(t, z.PeekBytes(20), []byte("ipsum dolor"), "must peek fewer bytes at EOF")
+}
+
+func TestLexerDataEOF(t *testing.T) {
+	z := NewLexerSize(&dataEOFReader{bytes.NewBufferString("Lorem ipsum")}, 8)
+	test.That(t, z.Peek(10) == 'm', "bytes returned with EOF must be peekable")
+	z.Move(10)
+	test.T(t, z.Err(), nil, "error must be nil while bytes remain")
+	z.Move(1)
+	test.T(t, z.Err(), io.EOF, "error must be EOF after the last byte")
+	test.Bytes(t, z.Shift(), []byte("Lorem ipsum"), "no bytes must be dropped")
+}
+
 func TestLexerSmall(t *testing.T) {
 	s := `abcdefghijklm`
 	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString(s)), 4)
@@ -105,6 +249,119 @@ func TestLexerSmall(t *testing.T) {
 	test.That(t, z.Peek(13) == 0, "must yield error at position 13")
 }
 
+func TestLexerSizeHint(t *testing.T) {
+	z := NewLexer(strings.NewReader("Lorem ipsum"))
+	test.That(t, cap(z.buf) == 12, "capacity must fit the length of the reader")
+	z.Move(11)
+	test.That(t, z.Peek(0) == 0, "must be EOF at position 11")
+	test.Bytes(t, z.Shift(), []byte("Lorem ipsum"), "must read all bytes")
+	test.That(t, len(z.pool.pool) == 0, "reading till EOF must not swap buffers")
+
+	defer func(n int) { MaxBuf = n }(MaxBuf)
+	MaxBuf = 8
+	z = NewLexer(strings.NewReader("Lorem ipsum"))
+	test.That(t, cap(z.buf) == 8, "capacity must be clamped to MaxBuf")
+	z = NewLexer(test.NewPlainReader(strings.NewReader("Lorem ipsum")))
+	test.That(t, cap(z.buf) == defaultBufSize, "capacity must default without a length")
+}
+
+func TestLexerActiveBuffer(t *testing.T) {
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum dolor")), 8)
+	z.Peek(0)
+	test.Bytes(t, z.ActiveBuffer(), []byte("Lorem ip"), "active buffer must contain the bytes read")
+	z.Move(6)
+	z.Free(len(z.Shift()))
+	z.Peek(8)
+	test.Bytes(t, z.ActiveBuffer(), []byte("ipsum dolor"), "active buffer must start at the unfinished token after a swap")
+	test.Bytes(t, z.ActiveBuffer()[z.start:z.start+5], []byte("ipsum"), "selection must lie within the active buffer")
+}
+
+func TestLexerDumpPool(t *testing.T) {
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum dolor")), 8)
+	z.Peek(0)
+	test.T(t, z.DumpPool(), "head=0 tail=0 pos=0\n", "pool must be empty before a swap")
+	z.Move(6)
+	z.Free(len(z.Shift()))
+	z.Peek(8)
+	test.T(t, z.DumpPool(), "head=1 tail=1 pos=6\n1: len=6 cap=8 active=true next=0\n", "dump must reflect the pool after a swap")
+}
+
+type netErrReader struct {
+	r io.Reader
+}
+
+func (r *netErrReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if err == io.EOF {
+		err = &net.OpError{Op: "read", Net: "tcp", Err: errTimeout}
+	}
+	return n, err
+}
+
+func TestLexerErr(t *testing.T) {
+	z := NewLexer(&netErrReader{bytes.NewBufferString("Lorem")})
+	test.That(t, z.Peek(4) == 'm', "must be 'm' at position 4")
+	test.T(t, z.Err(), nil, "error must be nil while bytes remain")
+	test.That(t, z.Peek(5) == 0, "must yield the reader's error at the end")
+
+	var opErr *net.OpError
+	test.That(t, errors.As(z.Err(), &opErr), "error must be the reader's net error")
+	test.That(t, errors.Is(z.Err(), errTimeout), "reader's error must be unwrappable")
+	test.That(t, !errors.Is(z.Err(), ErrExceeded), "reader's error must be distinct from ErrExceeded")
+}
+
+func TestLexerBytesCopy(t *testing.T) {
+	s := []byte("abcdefghijklmnopqrstuvwxyz")
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBuffer(s)), 8)
+	z.Peek(1)
+	z.Move(2)
+	lexeme := z.Lexeme()
+	b := z.BytesCopy()
+	z.Free(len(z.Shift()))
+	for z.Peek(2) != 0 {
+		z.Move(2)
+		z.Free(len(z.Shift()))
+	}
+	test.That(t, string(lexeme) != "ab", "retained lexeme must have been overwritten")
+	test.Bytes(t, b, []byte("ab"), "copy must be intact after buffers were reused")
+}
+
+func TestLexerMaxBuf(t *testing.T) {
+	defer func(n int) { MaxBuf = n }(MaxBuf)
+	MaxBuf = 16
+
+	s := "lorem ipsum dolor sit amet consectetur adipiscing elit"
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString(s)), 8)
+	for z.Peek(0) != 0 {
+		i := 0
+		for c := z.Peek(i); c != ' ' && c != 0; c = z.Peek(i) {
+			i++
+		}
+		z.Move(i + 1)
+		z.Free(len(z.Shift()))
+		test.That(t, cap(z.buf) <= MaxBuf, "buffer must not grow beyond MaxBuf")
+	}
+	test.T(t, z.Err(), io.EOF, "tokens below MaxBuf must be lexed till EOF")
+
+	z = NewLexerSize(test.NewPlainReader(bytes.NewBufferString(strings.Repeat("x", 40))), 8)
+	i := 0
+	for z.Peek(i) != 0 {
+		i++
+	}
+	test.That(t, i == 16, "must stop at MaxBuf")
+	test.That(t, cap(z.buf) <= MaxBuf, "buffer must not grow beyond MaxBuf")
+	test.That(t, errors.Is(z.Err(), ErrExceeded), "error must be ErrExceeded")
+}
+
+func TestLexerGrowthFactor(t *testing.T) {
+	defer func(f float64) { GrowthFactor = f }(GrowthFactor)
+	GrowthFactor = 1.5
+
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString(`abcdefghijklm`)), 8)
+	test.That(t, z.Peek(8) == 'i', "must be 'i' at position 8")
+	test.That(t, cap(z.buf) == 12+9, "buffer must grow by 1.5 plus the lookahead")
+}
+
 func TestLexerSingle(t *testing.T) {
 	z := NewLexer(test.NewInfiniteReader())
 	test.That(t, z.Peek(0) == '.')
@@ -139,3 +396,57 @@ func TestLexerEmptyReader(t *testing.T) {
 	test.T(t, z.Err(), io.EOF, "error must be EOF")
 	test.That(t, z.Peek(0) == 0, "second peek must also yield error")
 }
+
+func TestLexerSingleRead(t *testing.T) {
+	s := `Lorem ipsum`
+	z := NewLexerSize(test.NewPlainReader(bytes.NewBufferString(s)), 16)
+	test.That(t, z.Peek(0) == 'L', "first character must be 'L'")
+	z.Move(6)
+	lorem := z.Shift()
+	test.That(t, z.Peek(5) == 0, "must yield error at position 11")
+	test.T(t, z.Err(), nil, "error must be nil before reaching EOF")
+	z.Move(5)
+	test.T(t, z.Err(), io.EOF, "error must be EOF at the end")
+	test.That(t, len(z.pool.pool) == 0, "buffer pool must not be used")
+	test.Bytes(t, lorem, []byte("Lorem "), "shifted bytes must remain valid")
+}
+
+////////////////////////////////////////////////////////////////
+
+func BenchmarkLexerSingleRead(b *testing.B) {
+	s := bytes.Repeat([]byte("Lorem ipsum "), 100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		z := NewLexer(test.NewPlainReader(bytes.NewBuffer(s)))
+		for c := z.Peek(0); c != 0; c = z.Peek(0) {
+			z.Move(1)
+			if c == ' ' {
+				z.Shift()
+			}
+		}
+	}
+}
+
+func BenchmarkLexerStreaming(b *testing.B) {
+	s := bytes.Repeat([]byte("Lorem ipsum "), 100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		z := NewLexerSize(test.NewPlainReader(bytes.NewBuffer(s)), 64)
+		for c := z.Peek(0); c != 0; c = z.Peek(0) {
+			z.Move(1)
+			if c == ' ' {
+				z.Shift()
+			}
+		}
+	}
+}
+
+func BenchmarkLexerPeekRune(b *testing.B) {
+	s := bytes.Repeat([]byte("Lorem ipsum "), 100)
+	for i := 0; i < b.N; i++ {
+		z := NewLexer(bytes.NewBuffer(s))
+		for r, n := z.PeekRune(0); r != 0; r, n = z.PeekRune(0) {
+			z.Move(n)
+		}
+	}
+}
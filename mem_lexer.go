@@ -3,6 +3,7 @@ package buffer // import "github.com/tdewolff/buffer"
 import (
 	"io"
 	"io/ioutil"
+	"unicode/utf8"
 )
 
 var nullBuffer = []byte{0}
@@ -91,21 +92,18 @@ func (z *MemLexer) Err() error {
 // Peek returns zero when an error has occurred, Err returns the error.
 func (z *MemLexer) Peek(pos int) byte {
 	pos += z.pos
-	return z.buf[pos]
+	if uint(pos) < uint(len(z.buf)) { // uint for BCE
+		return z.buf[pos]
+	}
+	return 0
 }
 
-// PeekRune returns the rune and rune length of the ith byte relative to the end position.
+// PeekRune returns the rune and rune length of the ith byte relative to the end position. Invalid UTF-8 returns utf8.RuneError with length 1.
 func (z *MemLexer) PeekRune(pos int) (rune, int) {
-	// from unicode/utf8
-	c := z.Peek(pos)
-	if c < 0xC0 {
+	if c := z.Peek(pos); c < utf8.RuneSelf {
 		return rune(c), 1
-	} else if c < 0xE0 {
-		return rune(c&0x1F)<<6 | rune(z.Peek(pos+1)&0x3F), 2
-	} else if c < 0xF0 {
-		return rune(c&0x0F)<<12 | rune(z.Peek(pos+1)&0x3F)<<6 | rune(z.Peek(pos+2)&0x3F), 3
 	}
-	return rune(c&0x07)<<18 | rune(z.Peek(pos+1)&0x3F)<<12 | rune(z.Peek(pos+2)&0x3F)<<6 | rune(z.Peek(pos+3)&0x3F), 4
+	return decodeRune(z.Peek, pos)
 }
 
 // Move advances the position.
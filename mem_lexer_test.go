@@ -0,0 +1,31 @@
+package buffer // import "github.com/tdewolff/buffer"
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestMemLexer(t *testing.T) {
+	s := `Lorem ipsum`
+	z := NewMemLexer(bytes.NewBufferString(s))
+	test.That(t, z.Peek(0) == 'L', "first character must be 'L'")
+	z.Move(6)
+	test.Bytes(t, z.Shift(), []byte("Lorem "), "shift must return the buffered string")
+	test.That(t, z.Peek(4) == 'm', "must be 'm' at position 4 after shifting")
+	test.That(t, z.Peek(5) == 0, "sentinel must be zero")
+	z.Move(5)
+	test.T(t, z.Err(), io.EOF, "error must be EOF at the sentinel")
+}
+
+func TestMemLexerPeekBeyond(t *testing.T) {
+	z := NewMemLexerBytes([]byte("abc"))
+	test.That(t, z.Peek(3) == 0, "sentinel must be zero")
+	test.That(t, z.Peek(4) == 0, "one past the sentinel must be zero")
+	test.That(t, z.Peek(1000) == 0, "far beyond the sentinel must be zero")
+	z.Move(2)
+	test.That(t, z.Peek(2) == 0, "one past the sentinel must be zero after moving")
+	test.That(t, z.Peek(-3) == 0, "before the buffer must be zero")
+}
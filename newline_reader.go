@@ -0,0 +1,38 @@
+package buffer // import "github.com/tdewolff/buffer"
+
+import "io"
+
+type newlineReader struct {
+	r  io.Reader
+	cr bool // previous byte was \r
+}
+
+// NewNewlineReader returns an io.Reader that converts \r\n and lone \r to \n while reading from r, which can be wrapped by NewShifter or NewLexer so that lexers only need to handle \n.
+// A \r\n that is split over two reads is converted to a single \n.
+func NewNewlineReader(r io.Reader) io.Reader {
+	return &newlineReader{r: r}
+}
+
+func (r *newlineReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.r.Read(b)
+		j := 0
+		for i := 0; i < n; i++ {
+			c := b[i]
+			if c == '\n' && r.cr {
+				// \n of \r\n, the \r was already converted
+				r.cr = false
+				continue
+			}
+			r.cr = c == '\r'
+			if r.cr {
+				c = '\n'
+			}
+			b[j] = c
+			j++
+		}
+		if j != 0 || n == 0 || err != nil {
+			return j, err
+		}
+	}
+}
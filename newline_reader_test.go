@@ -0,0 +1,39 @@
+package buffer // import "github.com/tdewolff/buffer"
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestNewlineReader(t *testing.T) {
+	var newlineTests = []struct {
+		s        string
+		n        int
+		expected string
+	}{
+		{"lorem\r\nipsum\rdolor\n", 4, "lorem\nipsum\ndolor\n"},
+		{"lorem\r\nipsum", 6, "lorem\nipsum"},       // \r\n split over reads
+		{"lorem\r\n\r\nipsum", 1, "lorem\n\nipsum"}, // every byte in its own read
+		{"lorem\r\r\n", 1, "lorem\n\n"},
+		{"lorem\r", 2, "lorem\n"},
+		{"\r", 1, "\n"},
+		{"", 1, ""},
+	}
+	for _, tt := range newlineTests {
+		r := NewNewlineReader(&chunkReader{r: bytes.NewBufferString(tt.s), n: tt.n})
+		b, err := ioutil.ReadAll(r)
+		test.T(t, err, nil, "error")
+		test.T(t, string(b), tt.expected, "newlines must be normalized for", tt.s)
+	}
+
+	z := NewShifterSize(NewNewlineReader(&chunkReader{r: bytes.NewBufferString("lorem\r\nipsum\r"), n: 6}), 4)
+	line, _ := z.PeekLine()
+	test.Bytes(t, line, []byte("lorem"), "first line must match")
+	z.Move(6)
+	z.Skip()
+	z.MoveToEnd()
+	test.Bytes(t, z.Shift(), []byte("ipsum\n"), "trailing \\r must be converted")
+}
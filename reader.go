@@ -1,13 +1,22 @@
 package buffer // import "github.com/tdewolff/buffer"
 
-import "io"
+import (
+	"bytes"
+	"io"
+	"sync"
+)
 
-// Reader implements an io.Reader over a byte slice.
+// Reader implements an io.Reader over a byte slice. It also implements io.ReadSeeker and io.ReaderAt so that it can be used by packages such as archive/zip.
 type Reader struct {
 	buf []byte
 	pos int
 }
 
+var (
+	_ io.ReadSeeker = (*Reader)(nil)
+	_ io.ReaderAt   = (*Reader)(nil)
+)
+
 // NewReader returns a new Reader for a given byte slice.
 func NewReader(buf []byte) *Reader {
 	return &Reader{
@@ -15,6 +24,46 @@ func NewReader(buf []byte) *Reader {
 	}
 }
 
+// NewLimitReader returns a new Reader over at most n of the unread bytes of r, like io.LimitReader. Unlike io.LimitReader, the returned Reader implements Bytes so that Shifter and Lexer can use the in-memory buffer directly.
+// Reading from the returned Reader does not advance r.
+func NewLimitReader(r *Reader, n int) *Reader {
+	pos := r.pos
+	if len(r.buf) < pos {
+		pos = len(r.buf) // after seeking beyond the end
+	}
+	if n < 0 {
+		n = 0
+	}
+	end := len(r.buf)
+	if n < end-pos {
+		end = pos + n
+	}
+	return NewReader(r.buf[pos:end:end])
+}
+
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return &Reader{}
+	},
+}
+
+// GetReader returns a Reader for a given byte slice from a pool, which reduces allocations when wrapping many short-lived byte slices.
+// Return it to the pool with PutReader when done.
+func GetReader(buf []byte) *Reader {
+	r := readerPool.Get().(*Reader)
+	r.buf = buf
+	r.pos = 0
+	return r
+}
+
+// PutReader returns a Reader to the pool, the Reader must not be used afterwards.
+// It drops the reference to the byte slice so that the pool does not keep it in memory.
+func PutReader(r *Reader) {
+	r.buf = nil
+	r.pos = 0
+	readerPool.Put(r)
+}
+
 // Read reads bytes into the given byte slice and returns the number of bytes read and an error if occurred.
 func (r *Reader) Read(b []byte) (n int, err error) {
 	if len(b) == 0 {
@@ -28,6 +77,171 @@ func (r *Reader) Read(b []byte) (n int, err error) {
 	return
 }
 
+// ReadAt reads bytes into the given byte slice starting at offset off, like io.ReaderAt. It returns io.EOF when fewer than len(b) bytes were read. It does not change the read position.
+func (r *Reader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrSeek
+	} else if off >= int64(len(r.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(b, r.buf[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Seek sets the read position relative to the start, current position or end depending on whence, like io.Seeker. Seeking beyond the end is allowed, after which Read returns io.EOF. It returns ErrSeek for a negative position or an invalid whence.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += int64(r.pos)
+	case io.SeekEnd:
+		offset += int64(len(r.buf))
+	default:
+		return 0, ErrSeek
+	}
+	if offset < 0 {
+		return 0, ErrSeek
+	}
+	r.pos = int(offset)
+	return offset, nil
+}
+
+// ReadFull reads exactly len(b) bytes into the given byte slice, like io.ReadFull. It returns io.EOF when no bytes were read and io.ErrUnexpectedEOF when only part of the bytes were read.
+func (r *Reader) ReadFull(b []byte) (n int, err error) {
+	n, err = r.Read(b)
+	if n < len(b) && err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+func (r *Reader) readUint(n int, bigEndian bool) (uint64, error) {
+	if r.pos >= len(r.buf) {
+		return 0, io.EOF
+	} else if len(r.buf)-r.pos < n {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+
+	var v uint64
+	if bigEndian {
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+	} else {
+		for i := n - 1; i >= 0; i-- {
+			v = v<<8 | uint64(b[i])
+		}
+	}
+	return v, nil
+}
+
+// ReadUint16 reads a 16-bit unsigned integer in big or little endian byte order. It returns io.EOF when no bytes are left and io.ErrUnexpectedEOF when not enough bytes are left, in which case the position does not advance.
+func (r *Reader) ReadUint16(bigEndian bool) (uint16, error) {
+	v, err := r.readUint(2, bigEndian)
+	return uint16(v), err
+}
+
+// ReadUint32 reads a 32-bit unsigned integer in big or little endian byte order, see ReadUint16.
+func (r *Reader) ReadUint32(bigEndian bool) (uint32, error) {
+	v, err := r.readUint(4, bigEndian)
+	return uint32(v), err
+}
+
+// ReadUint64 reads a 64-bit unsigned integer in big or little endian byte order, see ReadUint16.
+func (r *Reader) ReadUint64(bigEndian bool) (uint64, error) {
+	return r.readUint(8, bigEndian)
+}
+
+// ReadUvarint reads an unsigned LEB128 varint as used by protocol buffers. It returns io.EOF when no bytes are left, io.ErrUnexpectedEOF when the varint is truncated and ErrOverflow when it overflows a 64-bit integer, in which cases the position does not advance.
+func (r *Reader) ReadUvarint() (uint64, error) {
+	if r.pos >= len(r.buf) {
+		return 0, io.EOF
+	}
+	var v uint64
+	for i := 0; i < 10; i++ {
+		if r.pos+i >= len(r.buf) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		c := r.buf[r.pos+i]
+		if c < 0x80 {
+			if i == 9 && c > 1 {
+				return 0, ErrOverflow
+			}
+			r.pos += i + 1
+			return v | uint64(c)<<(7*uint(i)), nil
+		}
+		v |= uint64(c&0x7F) << (7 * uint(i))
+	}
+	return 0, ErrOverflow
+}
+
+// ReadVarint reads a signed zig-zag encoded LEB128 varint as used by protocol buffers, see ReadUvarint.
+func (r *Reader) ReadVarint() (int64, error) {
+	u, err := r.ReadUvarint()
+	v := int64(u >> 1)
+	if u&1 != 0 {
+		v = ^v
+	}
+	return v, err
+}
+
+// ReadLine returns the next line excluding the trailing newline or CRLF, as a slice of the underlying byte slice. The last line is returned together with io.EOF if it is not terminated by a newline, and io.EOF alone is returned when no bytes are left.
+func (r *Reader) ReadLine() (line []byte, err error) {
+	if r.pos >= len(r.buf) {
+		return nil, io.EOF
+	}
+	b := r.buf[r.pos:]
+	i := bytes.IndexByte(b, '\n')
+	if i == -1 {
+		r.pos = len(r.buf)
+		return b, io.EOF
+	}
+	r.pos += i + 1
+	if 0 < i && b[i-1] == '\r' {
+		i--
+	}
+	return b[:i], nil
+}
+
+// Records returns an iterator over the unread records terminated by delim, excluding the delimiter. The last record is returned even if it is not terminated. Records are slices of the underlying byte slice and are not copied.
+// Each record advances the read position.
+func (r *Reader) Records(delim byte) func() ([]byte, bool) {
+	return func() ([]byte, bool) {
+		if r.pos >= len(r.buf) {
+			return nil, false
+		}
+		b := r.buf[r.pos:]
+		if i := bytes.IndexByte(b, delim); i != -1 {
+			r.pos += i + 1
+			return b[:i], true
+		}
+		r.pos = len(r.buf)
+		return b, true
+	}
+}
+
+// At returns the byte at absolute index i of the underlying byte slice and whether i is in range. It does not change the read position.
+func (r *Reader) At(i int) (byte, bool) {
+	if i < 0 || i >= len(r.buf) {
+		return 0, false
+	}
+	return r.buf[i], true
+}
+
+// Overwrite copies b over the underlying byte slice at offset off, which allows patching the data in-place. It returns io.ErrShortBuffer without copying when b does not fit. It does not change the read position.
+func (r *Reader) Overwrite(off int, b []byte) error {
+	if off < 0 || len(r.buf)-off < len(b) {
+		return io.ErrShortBuffer
+	}
+	copy(r.buf[off:], b)
+	return nil
+}
+
 // Bytes returns the underlying byte slice.
 func (r *Reader) Bytes() []byte {
 	return r.buf
@@ -42,3 +256,8 @@ func (r *Reader) Reset() {
 func (r *Reader) Len() int {
 	return len(r.buf)
 }
+
+// Size returns the length of the buffer as an int64, like bytes.Reader.
+func (r *Reader) Size() int64 {
+	return int64(len(r.buf))
+}
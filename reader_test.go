@@ -1,9 +1,14 @@
 package buffer // import "github.com/tdewolff/buffer"
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"sync"
 	"testing"
 
 	"github.com/tdewolff/test"
@@ -40,6 +45,308 @@ func TestReader(t *testing.T) {
 	test.Bytes(t, buf, []byte("abc"), "read after reset must match 'abc'")
 }
 
+func TestReaderReadFull(t *testing.T) {
+	r := NewReader([]byte("abcde"))
+	buf := make([]byte, 3)
+	n, err := r.ReadFull(buf)
+	test.T(t, err, nil, "error")
+	test.That(t, n == 3, "exact fill must read 3 characters")
+	test.Bytes(t, buf, []byte("abc"), "exact fill must match 'abc'")
+
+	n, err = r.ReadFull(buf)
+	test.T(t, err, io.ErrUnexpectedEOF, "partial fill must return ErrUnexpectedEOF")
+	test.That(t, n == 2, "partial fill must read 2 characters")
+	test.Bytes(t, buf[:n], []byte("de"), "partial fill must match 'de'")
+
+	n, err = r.ReadFull(buf)
+	test.T(t, err, io.EOF, "empty buffer must return EOF")
+	test.That(t, n == 0, "empty buffer must read 0 characters")
+
+	n, err = r.ReadFull(nil)
+	test.T(t, err, nil, "error")
+	test.That(t, n == 0, "read to nil buffer must return 0 characters read")
+}
+
+func TestReaderUint(t *testing.T) {
+	r := NewReader([]byte{0x01, 0x02, 0x01, 0x02, 0x01, 0x02, 0x03, 0x04, 0x01, 0x02, 0x03, 0x04})
+	v16, err := r.ReadUint16(true)
+	test.T(t, err, nil, "error")
+	test.That(t, v16 == 0x0102, "big endian uint16 must match")
+	v16, err = r.ReadUint16(false)
+	test.T(t, err, nil, "error")
+	test.That(t, v16 == 0x0201, "little endian uint16 must match")
+	v32, err := r.ReadUint32(true)
+	test.T(t, err, nil, "error")
+	test.That(t, v32 == 0x01020304, "big endian uint32 must match")
+	v32, err = r.ReadUint32(false)
+	test.T(t, err, nil, "error")
+	test.That(t, v32 == 0x04030201, "little endian uint32 must match")
+	_, err = r.ReadUint16(true)
+	test.T(t, err, io.EOF, "empty buffer must return EOF")
+
+	b := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	r = NewReader(b)
+	v64, err := r.ReadUint64(true)
+	test.T(t, err, nil, "error")
+	test.That(t, v64 == 0x0102030405060708, "big endian uint64 must match")
+	r.Reset()
+	v64, err = r.ReadUint64(false)
+	test.T(t, err, nil, "error")
+	test.That(t, v64 == 0x0807060504030201, "little endian uint64 must match")
+
+	r = NewReader(b[:1])
+	_, err = r.ReadUint16(true)
+	test.T(t, err, io.ErrUnexpectedEOF, "truncated uint16 must return ErrUnexpectedEOF")
+	r = NewReader(b[:3])
+	_, err = r.ReadUint32(false)
+	test.T(t, err, io.ErrUnexpectedEOF, "truncated uint32 must return ErrUnexpectedEOF")
+	r = NewReader(b[:7])
+	_, err = r.ReadUint64(true)
+	test.T(t, err, io.ErrUnexpectedEOF, "truncated uint64 must return ErrUnexpectedEOF")
+	n, _ := r.Read(make([]byte, 8))
+	test.That(t, n == 7, "truncated read must not advance")
+}
+
+func TestReaderVarint(t *testing.T) {
+	var uvarintTests = []uint64{0, 1, 127, 128, 300, 1<<32 - 1, math.MaxUint64}
+	for _, v := range uvarintTests {
+		b := make([]byte, binary.MaxVarintLen64)
+		r := NewReader(b[:binary.PutUvarint(b, v)])
+		u, err := r.ReadUvarint()
+		test.T(t, err, nil, "error")
+		test.That(t, u == v, "uvarint must match", v)
+		_, err = r.ReadUvarint()
+		test.T(t, err, io.EOF, "empty buffer must return EOF")
+	}
+
+	var varintTests = []int64{0, -1, 1, -64, 64, math.MinInt64, math.MaxInt64}
+	for _, v := range varintTests {
+		b := make([]byte, binary.MaxVarintLen64)
+		r := NewReader(b[:binary.PutVarint(b, v)])
+		i, err := r.ReadVarint()
+		test.T(t, err, nil, "error")
+		test.That(t, i == v, "varint must match", v)
+	}
+
+	r := NewReader([]byte{0xAC, 0x02, 0x01})
+	u, err := r.ReadUvarint()
+	test.T(t, err, nil, "error")
+	test.That(t, u == 300, "multi-byte uvarint must match")
+	u, err = r.ReadUvarint()
+	test.T(t, err, nil, "error")
+	test.That(t, u == 1, "single-byte uvarint must match")
+
+	r = NewReader([]byte{0xAC, 0x82})
+	_, err = r.ReadUvarint()
+	test.T(t, err, io.ErrUnexpectedEOF, "truncated uvarint must return ErrUnexpectedEOF")
+
+	r = NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x02})
+	_, err = r.ReadUvarint()
+	test.T(t, err, ErrOverflow, "10th byte larger than 1 must overflow")
+	r = NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01})
+	_, err = r.ReadUvarint()
+	test.T(t, err, ErrOverflow, "more than 10 bytes must overflow")
+}
+
+func TestLimitReader(t *testing.T) {
+	r := NewReader([]byte("abcdefgh"))
+	r.Read(make([]byte, 2))
+
+	lr := NewLimitReader(r, 3)
+	test.Bytes(t, lr.Bytes(), []byte("cde"), "limited reader must expose the limited bytes")
+	b, err := ioutil.ReadAll(lr)
+	test.T(t, err, nil, "error")
+	test.Bytes(t, b, []byte("cde"), "limited reader must read at most 3 bytes")
+
+	lr = NewLimitReader(r, 10)
+	test.Bytes(t, lr.Bytes(), []byte("cdefgh"), "limit beyond the length must expose all unread bytes")
+
+	z := NewShifter(NewLimitReader(r, 3))
+	test.That(t, z.IsEOF(), "shifter must use the in-memory fast path")
+	z.Move(3)
+	test.Bytes(t, z.Bytes(), []byte("cde"), "shifter must select the limited bytes")
+	test.T(t, z.Err(), io.EOF, "shifter must return EOF at the limit")
+
+	m := NewMemLexer(NewLimitReader(r, 3))
+	m.Move(3)
+	test.Bytes(t, m.Lexeme(), []byte("cde"), "mem lexer must select the limited bytes")
+	m.Restore()
+	test.Bytes(t, r.Bytes(), []byte("abcdefgh"), "underlying bytes must be unaffected")
+
+	lr = NewLimitReader(r, -1)
+	test.That(t, lr.Len() == 0, "negative limit must expose no bytes")
+	r.Seek(10, io.SeekStart)
+	lr = NewLimitReader(r, 3)
+	test.That(t, lr.Len() == 0, "limited reader beyond the end must expose no bytes")
+	_, err = lr.Read(make([]byte, 1))
+	test.T(t, err, io.EOF, "limited reader beyond the end must return EOF")
+}
+
+func TestReaderReadLine(t *testing.T) {
+	r := NewReader([]byte("lorem\nipsum\r\n\n\r\ndolor"))
+	var lineTests = []struct {
+		line string
+		err  error
+	}{
+		{"lorem", nil},
+		{"ipsum", nil},
+		{"", nil},
+		{"", nil},
+		{"dolor", io.EOF},
+		{"", io.EOF},
+	}
+	for _, tt := range lineTests {
+		line, err := r.ReadLine()
+		test.T(t, string(line), tt.line, "line must match")
+		test.T(t, err, tt.err, "error must match for line", tt.line)
+	}
+
+	r = NewReader([]byte("lorem\n"))
+	line, err := r.ReadLine()
+	test.T(t, string(line), "lorem", "terminated last line must match")
+	test.T(t, err, nil, "error must be nil for a terminated last line")
+	_, err = r.ReadLine()
+	test.T(t, err, io.EOF, "error must be EOF after the last line")
+}
+
+func TestReaderRecords(t *testing.T) {
+	var recordTests = []struct {
+		s       string
+		records []string
+	}{
+		{"lorem\nipsum\n", []string{"lorem", "ipsum"}},
+		{"lorem\nipsum", []string{"lorem", "ipsum"}},
+		{"lorem\n\n\nipsum", []string{"lorem", "", "", "ipsum"}},
+		{"\n", []string{""}},
+		{"", []string{}},
+	}
+	for _, tt := range recordTests {
+		next := NewReader([]byte(tt.s)).Records('\n')
+		records := []string{}
+		for record, ok := next(); ok; record, ok = next() {
+			records = append(records, string(record))
+		}
+		test.T(t, records, tt.records, "records must match for", tt.s)
+	}
+
+	r := NewReader([]byte("lorem ipsum"))
+	record, _ := r.Records(' ')()
+	test.Bytes(t, record, []byte("lorem"), "first record must be 'lorem'")
+	b, _ := ioutil.ReadAll(r)
+	test.Bytes(t, b, []byte("ipsum"), "records must advance the read position")
+}
+
+func TestReaderAt(t *testing.T) {
+	r := NewReader([]byte("abc"))
+	buf := make([]byte, 2)
+	r.Read(buf)
+
+	c, ok := r.At(0)
+	test.That(t, ok, "index 0 must be in range")
+	test.T(t, c, byte('a'), "first byte")
+	c, ok = r.At(2)
+	test.That(t, ok, "last index must be in range")
+	test.T(t, c, byte('c'), "last byte")
+	_, ok = r.At(3)
+	test.That(t, !ok, "index beyond the end must be out of range")
+	_, ok = r.At(-1)
+	test.That(t, !ok, "negative index must be out of range")
+
+	n, _ := r.Read(buf)
+	test.Bytes(t, buf[:n], []byte("c"), "At must not change the read position")
+}
+
+func TestReaderOverwrite(t *testing.T) {
+	r := NewReader([]byte("lorem ipsum"))
+	buf := make([]byte, 6)
+	r.Read(buf)
+
+	test.T(t, r.Overwrite(0, []byte("L")), nil, "error")
+	test.T(t, r.Overwrite(6, []byte("IPSUM")), nil, "error")
+	test.Bytes(t, r.Bytes(), []byte("Lorem IPSUM"), "bytes must be overwritten")
+	test.T(t, r.Overwrite(7, []byte("PSUMS")), io.ErrShortBuffer, "must not overwrite past the end")
+	test.T(t, r.Overwrite(-1, []byte("x")), io.ErrShortBuffer, "must not overwrite before the start")
+	test.Bytes(t, r.Bytes(), []byte("Lorem IPSUM"), "bytes must be unaffected by a failed overwrite")
+
+	n, _ := r.Read(buf)
+	test.Bytes(t, buf[:n], []byte("IPSUM"), "read position must be unaffected")
+}
+
+func TestReaderSeek(t *testing.T) {
+	r := NewReader([]byte("lorem ipsum"))
+	buf := make([]byte, 5)
+	pos, err := r.Seek(6, io.SeekStart)
+	test.T(t, err, nil, "error")
+	test.That(t, pos == 6, "position must be 6")
+	n, _ := r.Read(buf)
+	test.Bytes(t, buf[:n], []byte("ipsum"), "must read from the seeked position")
+
+	pos, err = r.Seek(-5, io.SeekCurrent)
+	test.That(t, err == nil && pos == 6, "must seek relative to the current position")
+	pos, err = r.Seek(-11, io.SeekEnd)
+	test.That(t, err == nil && pos == 0, "must seek relative to the end")
+	_, err = r.Seek(-1, io.SeekStart)
+	test.T(t, err, ErrSeek, "must not seek to a negative position")
+	_, err = r.Seek(0, 3)
+	test.T(t, err, ErrSeek, "must not seek with an invalid whence")
+	pos, err = r.Seek(20, io.SeekStart)
+	test.That(t, err == nil && pos == 20, "must seek beyond the end")
+	_, err = r.Read(buf)
+	test.T(t, err, io.EOF, "must read EOF beyond the end")
+
+	n, err = r.ReadAt(buf, 6)
+	test.T(t, err, nil, "error")
+	test.Bytes(t, buf[:n], []byte("ipsum"), "must read at the offset")
+	n, err = r.ReadAt(buf, 8)
+	test.T(t, err, io.EOF, "must return EOF when reading fewer bytes")
+	test.Bytes(t, buf[:n], []byte("sum"), "must read the remaining bytes")
+	_, err = r.ReadAt(buf, 11)
+	test.T(t, err, io.EOF, "must return EOF at the end")
+	test.That(t, r.Size() == 11, "size must be 11")
+}
+
+func TestReaderZip(t *testing.T) {
+	b := &bytes.Buffer{}
+	zw := zip.NewWriter(b)
+	w, _ := zw.Create("lorem.txt")
+	w.Write([]byte("lorem ipsum"))
+	zw.Close()
+
+	r := NewReader(b.Bytes())
+	zr, err := zip.NewReader(r, r.Size())
+	test.T(t, err, nil, "error")
+	test.That(t, len(zr.File) == 1, "zip must contain one file")
+	f, err := zr.File[0].Open()
+	test.T(t, err, nil, "error")
+	data, err := ioutil.ReadAll(f)
+	test.T(t, err, nil, "error")
+	test.Bytes(t, data, []byte("lorem ipsum"), "file contents must match")
+}
+
+func TestReaderPool(t *testing.T) {
+	r := GetReader([]byte("abc"))
+	PutReader(r)
+	test.That(t, r.Bytes() == nil, "put must drop the byte slice")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s := []byte(fmt.Sprintf("reader %d %d", i, j))
+				r := GetReader(s)
+				buf := make([]byte, len(s)+1)
+				n, err := r.Read(buf)
+				test.T(t, err, nil, "error")
+				test.Bytes(t, buf[:n], s, "recycled reader must read its own bytes")
+				PutReader(r)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
 func ExampleNewReader() {
 	r := NewReader([]byte("Lorem ipsum"))
 	w := &bytes.Buffer{}
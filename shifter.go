@@ -1,6 +1,15 @@
 package buffer // import "github.com/tdewolff/buffer"
 
-import "io"
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+// maxEmptyReads is the number of consecutive reads returning no data and no error after which io.ErrNoProgress is returned.
+const maxEmptyReads = 100
 
 // Shifter is a buffered reader that allows peeking forward and shifting, taking an io.Reader.
 type Shifter struct {
@@ -8,15 +17,26 @@ type Shifter struct {
 	err error
 	eof bool
 
-	buf []byte
-	pos int
-	end int
+	buf    []byte
+	pos    int
+	end    int
+	offset int // stream offset of buf
+
+	onRealloc func(old, new []byte)
+	retryEOF  bool
+
+	scratch    Writer
+	fieldDelim bool // previous ScanField ended on a delimiter
+
+	reads     int64
+	reallocs  int64
+	bytesRead int64
 }
 
-// NewShifter returns a new Shifter for a given io.Reader with a 4kB estimated buffer size.
+// NewShifter returns a new Shifter for a given io.Reader with a 4kB estimated buffer size, or sized to the remaining length up to MaxBuf if the io.Reader implements Len.
 // If the io.Reader implements Bytes, that buffer is used instead.
 func NewShifter(r io.Reader) *Shifter {
-	return NewShifterSize(r, defaultBufSize)
+	return NewShifterSize(r, sizeHint(r))
 }
 
 // NewShifterSize returns a new Shifter for a given io.Reader and estimated required buffer size.
@@ -26,10 +46,11 @@ func NewShifterSize(r io.Reader, size int) *Shifter {
 	if buffer, ok := r.(interface {
 		Bytes() []byte
 	}); ok {
+		b := buffer.Bytes()
 		return &Shifter{
 			err: io.EOF,
 			eof: true,
-			buf: buffer.Bytes(),
+			buf: b[:len(b):len(b)], // Feed must not append into the spare capacity of the io.Reader
 		}
 	}
 	z := &Shifter{
@@ -40,6 +61,99 @@ func NewShifterSize(r io.Reader, size int) *Shifter {
 	return z
 }
 
+// NewShifterSection returns a new Shifter with a 4kB estimated buffer size for the section of an io.ReaderAt of the given length starting at offset off. It returns EOF at the end of the section.
+// Since an io.ReaderAt has no read position, multiple Shifters can lex disjoint sections of the same io.ReaderAt concurrently.
+func NewShifterSection(r io.ReaderAt, off, length int64) *Shifter {
+	return NewShifter(io.NewSectionReader(r, off, length))
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+	buf []byte
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func (r *contextReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// read into a private buffer, as the read may still write to it after being abandoned
+	if cap(r.buf) < len(b) {
+		r.buf = make([]byte, len(b))
+	}
+	buf := r.buf[:len(b)]
+	c := make(chan readResult, 1)
+	go func() {
+		n, err := r.r.Read(buf)
+		c <- readResult{n, err}
+	}()
+
+	select {
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	case res := <-c:
+		return copy(b, buf[:res.n]), res.err
+	}
+}
+
+// NewShifterContext returns a new Shifter for a given io.Reader with a 4kB estimated buffer size, which aborts pending reads when ctx is cancelled.
+// Err returns ctx.Err() after cancellation. Only reading from the io.Reader has overhead, peeking at buffered bytes does not.
+// An aborted read keeps running in its own goroutine until the io.Reader returns, so callers should close the io.Reader after cancellation, such as a net.Conn, to release the goroutine.
+func NewShifterContext(ctx context.Context, r io.Reader) *Shifter {
+	return NewShifter(&contextReader{
+		ctx: ctx,
+		r:   r,
+	})
+}
+
+// ResetBytes resets the Shifter to read from the in-memory byte slice b, as if it were created by NewShifter for an io.Reader that implements Bytes. It allows reusing a Shifter for many small inputs without allocating.
+// The callback set by OnRealloc, the scratch buffer and the metrics are kept.
+func (z *Shifter) ResetBytes(b []byte) {
+	z.r = nil
+	z.err = io.EOF
+	z.eof = true
+	z.buf = b[:len(b):len(b)]
+	z.pos, z.end, z.offset = 0, 0, 0
+	z.retryEOF = false
+	z.fieldDelim = false
+}
+
+// Clone returns an independent Shifter with the same selection, which is useful for speculative parsing.
+// When IsEOF returns true the buffer is shared as it will not be overwritten anymore. Otherwise the clone detaches from the io.Reader at the current buffered extent: the buffered bytes from the start position onwards are copied and the clone returns EOF beyond them.
+func (z *Shifter) Clone() *Shifter {
+	err := z.err
+	if err == nil {
+		err = io.EOF
+	}
+	if z.IsEOF() {
+		// cap both buffers so that Feed or Pushback reallocate instead of writing into shared memory
+		z.buf = z.buf[:len(z.buf):len(z.buf)]
+		return &Shifter{
+			err:    err,
+			eof:    true,
+			buf:    z.buf,
+			pos:    z.pos,
+			end:    z.end,
+			offset: z.offset,
+		}
+	}
+	buf := make([]byte, len(z.buf)-z.pos)
+	copy(buf, z.buf[z.pos:])
+	return &Shifter{
+		err:    err,
+		eof:    true,
+		buf:    buf,
+		end:    z.end - z.pos,
+		offset: z.offset + z.pos,
+	}
+}
+
 // Err returns the error returned from io.Reader. It may still return valid bytes for a while though.
 func (z *Shifter) Err() error {
 	if z.eof && z.end < len(z.buf) {
@@ -48,67 +162,653 @@ func (z *Shifter) Err() error {
 	return z.err
 }
 
+// ClearErr clears the error returned from io.Reader so that subsequent calls to Peek retry reading, which is useful for io.Readers that can recover (eg. after a timeout).
+// EOF is sticky and cannot be cleared.
+func (z *Shifter) ClearErr() {
+	if z.err != io.EOF {
+		z.err = nil
+		z.eof = false
+	}
+}
+
+// Scratch returns a reusable Writer tied to the Shifter, which is reset on each call. It allows building decoded values, for example when unescaping strings, without allocating for every token.
+// The bytes returned by the Writer are invalidated by the next call to Scratch.
+func (z *Shifter) Scratch() *Writer {
+	z.scratch.Reset()
+	return &z.scratch
+}
+
+// OnRealloc registers a callback that is called whenever the buffer is reallocated, passing the old and new buffer. The bytes from the start position onwards in the old buffer are moved to the beginning of the new buffer.
+// This allows fixing up references into the buffer that would otherwise be invalidated. It is only called when a new buffer is allocated: Peek and Compact may instead move the bytes from the start position to the beginning of the same buffer, which invalidates references too but does not call the callback. References that must survive both should be kept as offsets relative to the start position.
+func (z *Shifter) OnRealloc(f func(old, new []byte)) {
+	z.onRealloc = f
+}
+
+// Cap returns the capacity of the internal buffer, which is the number of bytes that can be buffered before reallocating.
+func (z *Shifter) Cap() int {
+	return cap(z.buf)
+}
+
+// Metrics returns the number of calls to Read of io.Reader, the number of buffer reallocations and the total number of bytes read. This is useful for tuning the buffer size.
+func (z *Shifter) Metrics() (reads, reallocs, bytesRead int64) {
+	return z.reads, z.reallocs, z.bytesRead
+}
+
 // IsEOF returns true when it has encountered EOF meaning that it has loaded the last data in memory (ie. previously returned byte slice will not be overwritten by Peek).
 // Calling IsEOF is faster than checking Err() == io.EOF.
+// When retrying on EOF is enabled with SetRetryEOF, it always returns false since more data may be read later.
 func (z *Shifter) IsEOF() bool {
+	return z.eof && !z.retryEOF
+}
+
+// EOFReached returns true when io.Reader has returned EOF, regardless of whether buffered bytes remain after the end position. Together with Err it distinguishes three states: the source may have more data (EOFReached returns false), the source is exhausted but buffered bytes remain (EOFReached returns true and Err returns nil), and all bytes have been consumed (Err returns io.EOF).
+// Unlike IsEOF, it also returns true when retrying on EOF is enabled with SetRetryEOF, until more data is read.
+func (z *Shifter) EOFReached() bool {
 	return z.eof
 }
 
-func (z *Shifter) read(end int) byte {
+// SetRetryEOF sets whether EOF from io.Reader is retried, ie. treated as no data being available at the moment so that subsequent calls to Peek read again. This is useful for sources that grow, such as when following a file that is being written to.
+// Err still returns EOF when at the end of the available data. It has no effect for in-memory buffers.
+func (z *Shifter) SetRetryEOF(retry bool) {
+	z.retryEOF = retry && z.r != nil
+}
+
+func (z *Shifter) read(end int, once bool) byte {
 	if z.err != nil {
-		return 0
+		if !z.retryEOF || z.err != io.EOF {
+			return 0
+		}
+		z.err = nil
+		z.eof = false
 	}
 
 	// reallocate a new buffer (possibly larger)
 	c := cap(z.buf)
 	d := len(z.buf) - z.pos
 	var buf []byte
-	if 2*d > c {
-		buf = make([]byte, d, 2*c+end-z.pos)
+	realloc := 2*d > c && z.pos != 0 || end-z.pos >= c // without a start offset there is nothing to move, read into the spare capacity instead
+	if realloc {
+		n := grow(c) + end - z.pos
+		if n <= end-z.pos {
+			n = end - z.pos + 1 // an empty buffer does not grow, but must fit at least the byte at end
+		}
+		buf = make([]byte, d, n)
 	} else {
 		buf = z.buf[:d]
 	}
 	copy(buf, z.buf[z.pos:])
+	oldBuf := z.buf
 
-	// read in to fill the buffer till capacity
-	var n int
-	n, z.err = z.r.Read(buf[d:cap(buf)])
-	z.eof = (z.err == io.EOF)
+	// read in to fill the buffer till capacity, and at least till end unless we read only once
 	end -= z.pos
 	z.end -= z.pos
-	z.pos, z.buf = 0, buf[:d+n]
-	if n == 0 {
-		if z.err == nil {
-			z.err = io.EOF
-			z.eof = true
+	z.offset += z.pos
+	z.pos = 0
+	var n int
+	empty := 0
+	for end >= d && z.err == nil {
+		n, z.err = z.r.Read(buf[d:cap(buf)])
+		d += n
+		z.reads++
+		z.bytesRead += int64(n)
+		if once {
+			break
+		} else if n == 0 && z.err == nil {
+			// retry reads that return no data, but not indefinitely
+			if empty++; empty == maxEmptyReads {
+				z.err = io.ErrNoProgress
+			}
+		} else {
+			empty = 0
+		}
+	}
+	z.eof = (z.err == io.EOF)
+	z.buf = buf[:d]
+	if realloc {
+		z.reallocs++
+		if z.onRealloc != nil {
+			z.onRealloc(oldBuf, z.buf)
 		}
+	}
+	if end >= d {
 		return 0
 	}
 	return z.buf[end]
 }
 
-// Peek returns the ith byte relative to the end position and possibly does an allocation. Calling Peek may invalidate previous returned byte slices by Bytes or Shift, unless IsEOF returns true.
+// Peek returns the ith byte relative to the end position and possibly does an allocation. Calling Peek may invalidate previous returned byte slices by Bytes or ShiftNoCopy, unless IsEOF returns true.
 // Peek returns zero when an error has occurred, Err returns the error.
 func (z *Shifter) Peek(end int) byte {
 	end += z.end
 	if end >= len(z.buf) {
-		return z.read(end)
+		return z.read(end, false)
 	}
 	return z.buf[end]
 }
 
-// PeekRune returns the rune and rune length of the ith byte relative to the end position.
+// Feed appends b to the buffered bytes as if it were read from io.Reader, which allows using the Shifter as a push parser when data arrives by callbacks. Peek beyond the fed bytes reads from io.Reader as usual.
+// For push parsing, create the Shifter with an empty in-memory reader such as NewReader(nil), in which case Peek returns zero beyond the fed bytes as if at EOF. Like Peek, it may invalidate the byte slices previously returned by Bytes or ShiftNoCopy.
+func (z *Shifter) Feed(b []byte) {
+	z.reserve(len(b))
+	z.buf = append(z.buf, b...)
+}
+
+// Pushback inserts b before the byte at the end position, so that the next Peek(0) returns b[0] followed by the remainder of b and then the original bytes. This allows re-injecting tokens, for example for macro expansion. The selection is unaffected.
+// If the end position was moved beyond EOF, b is inserted at EOF. Like Peek, it may invalidate the byte slices previously returned by Bytes or ShiftNoCopy.
+func (z *Shifter) Pushback(b []byte) {
+	if z.end > len(z.buf) {
+		z.end = len(z.buf)
+	}
+	z.reserve(len(b))
+	z.buf = z.buf[:len(z.buf)+len(b)]
+	copy(z.buf[z.end+len(b):], z.buf[z.end:])
+	copy(z.buf[z.end:], b)
+}
+
+// reserve reallocates the buffer when it has no spare capacity for n bytes, dropping the bytes before the start position.
+func (z *Shifter) reserve(n int) {
+	if n <= cap(z.buf)-len(z.buf) {
+		return
+	}
+	d := len(z.buf) - z.pos
+	buf := make([]byte, d, grow(cap(z.buf))+n)
+	copy(buf, z.buf[z.pos:])
+	oldBuf := z.buf
+	z.end -= z.pos
+	z.offset += z.pos
+	z.pos, z.buf = 0, buf
+	z.reallocs++
+	if z.onRealloc != nil {
+		z.onRealloc(oldBuf, z.buf)
+	}
+}
+
+// Compact moves the bytes from the start position onwards to the front of the buffer without reallocating, so that the start position becomes zero. It allows reclaiming the front of the buffer before it is needed by Peek. It is a no-op for in-memory buffers and once IsEOF returns true, so that the byte slices returned by Shift remain valid.
+// Like Peek, it invalidates the byte slices previously returned by Bytes or ShiftNoCopy.
+func (z *Shifter) Compact() {
+	if z.r == nil || z.eof || z.pos == 0 {
+		return
+	}
+	d := copy(z.buf, z.buf[z.pos:])
+	z.buf = z.buf[:d]
+	z.end -= z.pos
+	z.offset += z.pos
+	z.pos = 0
+}
+
+// ShrinkBuffer reallocates the buffer to the default buffer size when it has grown larger, for example after a big token, and the bytes from the start position onwards fit in the default buffer size. It is a no-op for in-memory buffers.
+// Like Peek, it invalidates the byte slices previously returned by Bytes or ShiftNoCopy.
+func (z *Shifter) ShrinkBuffer() {
+	d := len(z.buf) - z.pos
+	if z.r == nil || cap(z.buf) <= defaultBufSize || d > defaultBufSize {
+		return
+	}
+	buf := make([]byte, d, defaultBufSize)
+	copy(buf, z.buf[z.pos:])
+	oldBuf := z.buf
+	z.end -= z.pos
+	z.offset += z.pos
+	z.pos, z.buf = 0, buf
+	z.reallocs++
+	if z.onRealloc != nil {
+		z.onRealloc(oldBuf, z.buf)
+	}
+}
+
+// TryPeek returns the ith byte relative to the end position like Peek, but it calls Read on io.Reader at most once to satisfy the request. The boolean reports whether the byte was available.
+// Unlike Peek, it does not loop over short reads, which allows callers driving non-blocking readers from an event loop to read one step at a time.
+func (z *Shifter) TryPeek(end int) (byte, bool) {
+	if z.end+end >= len(z.buf) {
+		z.read(z.end+end, true)
+		if z.end+end >= len(z.buf) {
+			return 0, false
+		}
+	}
+	return z.buf[z.end+end], true
+}
+
+// PeekBuffered returns the ith byte relative to the end position only if it is buffered already, so that it never reads from io.Reader. The boolean reports whether the byte was available.
+func (z *Shifter) PeekBuffered(end int) (byte, bool) {
+	end += z.end
+	if end >= len(z.buf) {
+		return 0, false
+	}
+	return z.buf[end], true
+}
+
+// PeekAt returns the ith byte relative to the start position, which allows re-examining bytes of the current selection. It never reads from io.Reader and returns zero when the byte is not buffered.
+func (z *Shifter) PeekAt(i int) byte {
+	i += z.pos
+	if i < 0 || i >= len(z.buf) {
+		return 0
+	}
+	return z.buf[i]
+}
+
+// FillBuffer reads from io.Reader until at least min bytes after the end position are buffered, so that they can be peeked without reading. It is a no-op when enough bytes are buffered already.
+// It returns the error from io.Reader when less than min bytes could be buffered.
+func (z *Shifter) FillBuffer(min int) error {
+	for len(z.buf)-z.end < min && z.err == nil {
+		z.read(len(z.buf), false)
+	}
+	if len(z.buf)-z.end < min {
+		return z.err
+	}
+	return nil
+}
+
+// PeekLimited is like Peek but refuses to look further than max bytes from the start position, ie. the selection plus the lookahead may not exceed max bytes.
+// When the limit is exceeded it returns zero and Err returns ErrExceeded, unless another error occurred before. Like errors of io.Reader, ErrExceeded is kept: afterwards Peek only returns the bytes that were buffered already, even after shifting, and the Shifter must be reset or discarded. Peek has no such limit and grows the buffer as far as needed, which makes PeekLimited suitable for untrusted input.
+// The limit does not apply once IsEOF returns true, as all data is in memory already.
+func (z *Shifter) PeekLimited(end, max int) byte {
+	if !z.eof && z.end+end-z.pos >= max {
+		if z.err == nil {
+			z.err = ErrExceeded
+		}
+		return 0
+	}
+	return z.Peek(end)
+}
+
+// CountLines returns the number of newlines in the range [start,end) relative to the start position, so that a CRLF counts as one line ending. It never reads from io.Reader and only counts within the buffered bytes.
+func (z *Shifter) CountLines(start, end int) int {
+	start += z.pos
+	end += z.pos
+	if start < z.pos {
+		start = z.pos
+	}
+	if len(z.buf) < end {
+		end = len(z.buf)
+	}
+	if end <= start {
+		return 0
+	}
+	return bytes.Count(z.buf[start:end], []byte{'\n'})
+}
+
+// PeekLine returns the bytes from the end position up to the next newline, excluding the newline or CRLF, without moving the end position. The boolean reports whether a newline was found before EOF.
+// Like PeekLimited, it does not look further than MaxBuf bytes from the start position and returns the bytes peeked so far and false when exceeded, after which Err returns ErrExceeded and the Shifter no longer reads from io.Reader.
+// Like Bytes, the returned slice may be invalidated by Peek unless IsEOF returns true.
+func (z *Shifter) PeekLine() ([]byte, bool) {
+	i := 0
+	for {
+		c := z.PeekLimited(i, MaxBuf)
+		if c == '\n' {
+			break
+		} else if c == 0 && (z.end+i >= len(z.buf) || z.err == ErrExceeded) {
+			return z.buf[z.end : z.end+i], false
+		}
+		i++
+	}
+	if 0 < i && z.buf[z.end+i-1] == '\r' {
+		return z.buf[z.end : z.end+i-1], true
+	}
+	return z.buf[z.end : z.end+i], true
+}
+
+// PeekRune returns the rune and rune length of the ith byte relative to the end position. Invalid UTF-8 returns utf8.RuneError with length 1.
+// Runes are always decoded from the buffered bytes, even if the io.Reader implements io.RuneReader, since the selection must be kept as bytes and reading rune by rune would be slower than reading in bulk.
 func (z *Shifter) PeekRune(i int) (rune, int) {
-	// from unicode/utf8
-	c := z.Peek(i)
-	if c < 0xC0 {
+	if c := z.Peek(i); c < utf8.RuneSelf {
 		return rune(c), 1
-	} else if c < 0xE0 {
-		return rune(c&0x1F)<<6 | rune(z.Peek(i+1)&0x3F), 2
-	} else if c < 0xF0 {
-		return rune(c&0x0F)<<12 | rune(z.Peek(i+1)&0x3F)<<6 | rune(z.Peek(i+2)&0x3F), 3
 	}
-	return rune(c&0x07)<<18 | rune(z.Peek(i+1)&0x3F)<<12 | rune(z.Peek(i+2)&0x3F)<<6 | rune(z.Peek(i+3)&0x3F), 4
+	return decodeRune(z.Peek, i)
+}
+
+// AcceptRunTable advances the end position over all consecutive bytes in set and returns the number of bytes advanced.
+// It scans the buffered bytes in a tight loop and only reads when reaching the end of the buffer, which is much faster than calling Peek for every byte.
+func (z *Shifter) AcceptRunTable(set *Charset) int {
+	start := z.end - z.pos // read may move the buffer
+	for {
+		buf, i := z.buf, z.end
+		for i < len(buf) && set[buf[i]] {
+			i++
+		}
+		z.end = i
+		if i < len(buf) || z.err != nil {
+			break
+		}
+		z.Peek(0)
+	}
+	return z.end - z.pos - start
+}
+
+// scan advances the end position over the number of buffered bytes returned by span, which is passed the bytes from the end position, and reads more while span accepts all of them. It returns the number of bytes advanced.
+func (z *Shifter) scan(span func([]byte) int) int {
+	start := z.end - z.pos // read may move the buffer
+	for {
+		if z.end < len(z.buf) {
+			z.end += span(z.buf[z.end:])
+		}
+		if z.end < len(z.buf) || z.err != nil {
+			break
+		}
+		z.Peek(0)
+	}
+	return z.end - z.pos - start
+}
+
+// MoveWhile advances the end position while pred returns true for the byte at the end position, reading as needed, and returns the number of bytes advanced. It stops at EOF.
+func (z *Shifter) MoveWhile(pred func(byte) bool) int {
+	return z.scan(func(buf []byte) int {
+		i := 0
+		for i < len(buf) && pred(buf[i]) {
+			i++
+		}
+		return i
+	})
+}
+
+// ScanWhile advances the end position over all consecutive bytes in set and returns the number of bytes advanced and the next byte that is not in set, which is zero at EOF.
+func (z *Shifter) ScanWhile(set *Charset) (int, byte) {
+	n := z.AcceptRunTable(set)
+	return n, z.Peek(0)
+}
+
+// ScanUntilAny advances the end position until a byte in set, which is not consumed, or EOF and returns the number of bytes advanced. Like AcceptRunTable it scans the buffered bytes in a tight loop.
+func (z *Shifter) ScanUntilAny(set *Charset) int {
+	start := z.end - z.pos // read may move the buffer
+	for {
+		buf, i := z.buf, z.end
+		for i < len(buf) && !set[buf[i]] {
+			i++
+		}
+		z.end = i
+		if i < len(buf) || z.err != nil {
+			break
+		}
+		z.Peek(0)
+	}
+	return z.end - z.pos - start
+}
+
+var identifierCharset = letterCharset.Or(digitCharset).Or(MakeCharset("_"))
+
+// ScanIdentifier advances the end position over an ASCII identifier, ie. a letter or underscore followed by letters, digits or underscores, and returns its length.
+// It returns zero if the byte at the end position cannot start an identifier.
+func (z *Shifter) ScanIdentifier() int {
+	if c := z.Peek(0); c != '_' && (c < 'a' || 'z' < c) && (c < 'A' || 'Z' < c) {
+		return 0
+	}
+	return z.AcceptRunTable(&identifierCharset)
+}
+
+// ScanField scans a CSV field at the end position and collapses the position to after its delimiter or record terminator. Fields may be quoted to contain delimiters, newlines and doubled quotes, which are unescaped to a single quote. It returns the field, whether the field ended the record by a newline, CRLF or EOF, and an error if occurred.
+// A delimiter directly before EOF is followed by an empty field that ends the record, like encoding/csv.
+// It returns io.EOF when there are no more fields and io.ErrUnexpectedEOF for an unterminated quoted field. It returns ErrQuote for a quote in an unquoted field or bytes after a closing quote, together with the unquoted field, and still moves past the field so that scanning can continue. The returned field may be invalidated by Peek like the bytes returned by ShiftNoCopy, unless IsEOF returns true or it contained doubled quotes.
+func (z *Shifter) ScanField(delim, quote byte) ([]byte, bool, error) {
+	isEOF := func(i int) bool {
+		return z.Peek(i) == 0 && z.end+i >= len(z.buf)
+	}
+	if isEOF(0) {
+		if z.fieldDelim { // trailing empty field after a delimiter
+			z.fieldDelim = false
+			return z.buf[z.end:z.end], true, z.fieldErr(nil)
+		}
+		return nil, true, z.fieldErr(io.EOF)
+	}
+
+	i, start, end := 0, 0, 0
+	escaped := false
+	if z.Peek(0) == quote {
+		i, start = 1, 1
+		for {
+			if isEOF(i) {
+				b := z.buf[z.end+start : z.end+i]
+				z.end += i
+				z.Skip()
+				z.fieldDelim = false
+				return b, true, z.fieldErr(io.ErrUnexpectedEOF)
+			} else if z.Peek(i) == quote {
+				if z.Peek(i+1) != quote {
+					break
+				}
+				escaped = true
+				i++
+			}
+			i++
+		}
+		end = i
+		i++
+	}
+
+	// find the delimiter or record terminator, no other bytes may follow a closing quote
+	n := 0
+	var err error
+	for {
+		c := z.Peek(i)
+		if c == delim || c == '\n' {
+			n = 1
+			break
+		} else if c == '\r' && z.Peek(i+1) == '\n' {
+			n = 2
+			break
+		} else if isEOF(i) {
+			break
+		} else if start != 0 || c == quote {
+			err = ErrQuote
+		}
+		i++
+	}
+	if start == 0 {
+		end = i
+	}
+	record := n == 0 || z.Peek(i) != delim
+
+	b := z.buf[z.end+start : z.end+end]
+	if escaped {
+		field := make([]byte, 0, len(b))
+		for j := 0; j < len(b); j++ {
+			field = append(field, b[j])
+			if b[j] == quote {
+				j++
+			}
+		}
+		b = field
+	}
+	z.end += i + n
+	z.Skip()
+	z.fieldDelim = !record
+	return b, record, z.fieldErr(err)
+}
+
+func (z *Shifter) fieldErr(err error) error {
+	if z.err != nil && z.err != io.EOF {
+		return z.err
+	}
+	return err
+}
+
+// RuneAhead returns the nth rune after the end position, its byte length and its byte offset relative to the end position. Invalid UTF-8 counts as one utf8.RuneError rune of length 1.
+// RuneAhead returns a zero rune when an error has occurred, Err returns the error.
+func (z *Shifter) RuneAhead(n int) (rune, int, int) {
+	i := 0
+	for {
+		if z.Peek(i) == 0 && z.end+i >= len(z.buf) {
+			return 0, 1, i
+		}
+		r, size := decodeRune(z.Peek, i) // only peeks as many bytes as the rune needs
+		if n == 0 {
+			return r, size, i
+		}
+		i += size
+		n--
+	}
+}
+
+// ScanString advances the end position over a quoted string starting at the end position, where a backslash escapes the next byte. It returns the length of the string including the quotes and whether the closing quote was found before EOF.
+// It returns zero and false if the string does not start with quote.
+func (z *Shifter) ScanString(quote byte) (int, bool) {
+	if z.Peek(0) != quote {
+		return 0, false
+	}
+	i := 1
+	for {
+		c := z.Peek(i)
+		if c == 0 && z.end+i >= len(z.buf) {
+			z.end += i
+			return i, false
+		}
+		i++
+		if c == quote {
+			z.end += i
+			return i, true
+		} else if c == '\\' && (z.Peek(i) != 0 || z.end+i < len(z.buf)) {
+			i++
+		}
+	}
+}
+
+// ScanJSONNumber advances the end position over a number as defined by JSON, which is an optional minus sign, an integer part without leading zeros, an optional fraction and an optional exponent. It returns the length of the number and whether it is valid.
+// A malformed number such as 01 or 1. is consumed up to where it becomes invalid. It returns zero and false if the bytes at the end position do not start with an integer part, such as .5.
+func (z *Shifter) ScanJSONNumber() (int, bool) {
+	i := 0
+	if z.Peek(0) == '-' {
+		i++
+	}
+	if c := z.Peek(i); c == '0' {
+		i++
+		if n := z.digits(i); n != 0 {
+			z.end += i + n
+			return i + n, false
+		}
+	} else if '1' <= c && c <= '9' {
+		i += z.digits(i)
+	} else {
+		return 0, false
+	}
+	ok := true
+	if z.Peek(i) == '.' {
+		i++
+		n := z.digits(i)
+		i += n
+		ok = n != 0
+	}
+	if c := z.Peek(i); ok && (c == 'e' || c == 'E') {
+		i++
+		if c := z.Peek(i); c == '+' || c == '-' {
+			i++
+		}
+		n := z.digits(i)
+		i += n
+		ok = n != 0
+	}
+	z.end += i
+	return i, ok
+}
+
+func (z *Shifter) digits(i int) int {
+	n := 0
+	for c := z.Peek(i + n); '0' <= c && c <= '9'; c = z.Peek(i + n) {
+		n++
+	}
+	return n
+}
+
+// SkipLineComment advances the end position over a line comment if the bytes at the end position match prefix. It stops before the line ending or at EOF and returns whether a comment was found.
+func (z *Shifter) SkipLineComment(prefix []byte) bool {
+	if !z.match(0, prefix) {
+		return false
+	}
+	i := len(prefix)
+	for {
+		c := z.Peek(i)
+		if c == '\n' || c == '\r' || c == 0 && z.end+i >= len(z.buf) {
+			break
+		}
+		i++
+	}
+	z.end += i
+	return true
+}
+
+// SkipBlockComment advances the end position over a block comment if the bytes at the end position match open, up to and including the first close. Block comments do not nest.
+// It returns whether the comment was terminated, an unterminated comment is consumed till EOF.
+func (z *Shifter) SkipBlockComment(open, close []byte) bool {
+	if !z.match(0, open) {
+		return false
+	}
+	i := len(open)
+	for !z.match(i, close) {
+		if z.Peek(i) == 0 && z.end+i >= len(z.buf) {
+			z.end += i
+			return false
+		}
+		i++
+	}
+	z.end += i + len(close)
+	return true
+}
+
+// SkipUntilPattern advances the end position up to the next occurrence of pat and collapses the position to the end, which is useful to resynchronize at a known marker after an error. It returns whether pat was found, otherwise it skips till EOF.
+// Skipped bytes are discarded while reading, so the buffer does not grow when pat is far away.
+func (z *Shifter) SkipUntilPattern(pat []byte) bool {
+	for {
+		if i := bytes.Index(z.buf[z.end:], pat); i != -1 {
+			z.end += i
+			z.Skip()
+			return true
+		}
+		// keep the bytes that may be the start of pat
+		if keep := len(z.buf) - len(pat) + 1; z.end < keep {
+			z.end = keep
+		}
+		z.Skip()
+		if n := len(z.buf) - z.end; z.Peek(n) == 0 && z.end+n >= len(z.buf) {
+			z.end = len(z.buf)
+			z.Skip()
+			return false
+		}
+	}
+}
+
+func (z *Shifter) match(i int, b []byte) bool {
+	if len(b) == 0 || z.Peek(i+len(b)-1) == 0 && z.end+i+len(b) > len(z.buf) {
+		return len(b) == 0
+	}
+	return string(z.buf[z.end+i:z.end+i+len(b)]) == string(b)
+}
+
+// ScanDigits advances the end position over consecutive digits in the given base (2 to 36) and returns their value and the number of digits. Letters are case-insensitive for bases larger than 10, and prefixes such as 0x are not consumed.
+// The returned boolean is false when the value overflows an uint64, in which case all digits are still consumed.
+func (z *Shifter) ScanDigits(base int) (uint64, int, bool) {
+	var v uint64
+	ok := true
+	i := 0
+	for {
+		c := z.Peek(i)
+		var d int
+		if '0' <= c && c <= '9' {
+			d = int(c - '0')
+		} else if 'a' <= c && c <= 'z' {
+			d = int(c-'a') + 10
+		} else if 'A' <= c && c <= 'Z' {
+			d = int(c-'A') + 10
+		} else {
+			break
+		}
+		if d >= base {
+			break
+		}
+		if ok {
+			if v > (math.MaxUint64-uint64(d))/uint64(base) {
+				ok = false
+			} else {
+				v = v*uint64(base) + uint64(d)
+			}
+		}
+		i++
+	}
+	z.end += i
+	if !ok {
+		return math.MaxUint64, i, false
+	}
+	return v, i, true
+}
+
+// ScanHex advances the end position over consecutive hexadecimal digits, see ScanDigits.
+func (z *Shifter) ScanHex() (uint64, int, bool) {
+	return z.ScanDigits(16)
 }
 
 // Move advances the end position.
@@ -116,29 +816,246 @@ func (z *Shifter) Move(n int) {
 	z.end += n
 }
 
+// Advance advances the end position by n bytes and returns the bytes moved over, reading as needed. It returns fewer bytes when EOF is reached.
+// Like Bytes, the returned slice may be invalidated by Peek unless IsEOF returns true.
+func (z *Shifter) Advance(n int) []byte {
+	if n <= 0 {
+		return z.buf[z.end:z.end]
+	}
+	z.Peek(n - 1)
+	if len(z.buf)-z.end < n {
+		n = len(z.buf) - z.end
+	}
+	z.end += n
+	return z.buf[z.end-n : z.end]
+}
+
+// CopyN copies the next n bytes after the end position into dst, reading as needed, and advances the end position by the number of bytes copied. It copies at most len(dst) bytes.
+// It returns io.EOF when fewer bytes were available, or the error from io.Reader.
+func (z *Shifter) CopyN(dst []byte, n int) (int, error) {
+	if len(dst) < n {
+		n = len(dst)
+	}
+	m := copy(dst, z.Advance(n))
+	if m < n {
+		if z.err != nil && z.err != io.EOF {
+			return m, z.err
+		}
+		return m, io.EOF
+	}
+	return m, nil
+}
+
+// Back moves the end position backward by n bytes, which is useful after peeking and moving too far. It does not move before the start position.
+func (z *Shifter) Back(n int) {
+	z.end -= n
+	if z.end < z.pos {
+		z.end = z.pos
+	}
+}
+
+// MoveRune advances the end position by the byte length of the rune at the end position and returns that length. Invalid UTF-8 advances by one byte.
+// It returns zero at EOF.
+func (z *Shifter) MoveRune() int {
+	_, n := z.PeekRune(0)
+	if z.end >= len(z.buf) {
+		return 0
+	}
+	z.end += n
+	return n
+}
+
+// MoveToEnd reads in the remainder of io.Reader and advances the end position to the end of the data, so that the remainder can be shifted as a single token.
+// Afterwards IsEOF returns true, unless another error occurred.
+func (z *Shifter) MoveToEnd() {
+	for z.err == nil {
+		z.read(len(z.buf), false)
+	}
+	z.end = len(z.buf)
+}
+
 // MoveTo sets the end position.
 func (z *Shifter) MoveTo(n int) {
 	z.end = z.pos + n
 }
 
+// Rewind sets the end position, it equals MoveTo and matches the naming of Lexer and MemLexer.
+func (z *Shifter) Rewind(pos int) {
+	z.MoveTo(pos)
+}
+
 // Pos returns the end position.
 func (z *Shifter) Pos() int {
 	return z.end - z.pos
 }
 
+// ShifterState is the state of a Shifter as saved by Snapshot.
+type ShifterState struct {
+	pos int // stream offset
+	end int // stream offset
+}
+
+// Snapshot returns the start and end position so that the Shifter can be restored to it later, even after several shifts.
+// A snapshot is invalidated when the bytes it references are shifted and then discarded by Peek, unless IsEOF returns true.
+func (z *Shifter) Snapshot() ShifterState {
+	return ShifterState{z.offset + z.pos, z.offset + z.end}
+}
+
+// Restore restores the start and end position to a previous snapshot.
+func (z *Shifter) Restore(s ShifterState) {
+	z.pos = s.pos - z.offset
+	z.end = s.end - z.offset
+}
+
+// Seek collapses the start and end position to the given offset, which allows multiple passes over in-memory data. It is only supported when the io.Reader implements Bytes so that all data is in memory, and returns ErrSeek otherwise or when the offset is out of range.
+func (z *Shifter) Seek(offset int) error {
+	offset -= z.offset
+	if z.r != nil || offset < 0 || len(z.buf) < offset {
+		return ErrSeek
+	}
+	z.pos, z.end = offset, offset
+	return nil
+}
+
 // Bytes returns the bytes of the current selection.
+// It returns an empty slice when the end position was moved before the start position, instead of panicking.
 func (z *Shifter) Bytes() []byte {
+	if z.end < z.pos {
+		return z.buf[z.pos:z.pos]
+	}
 	return z.buf[z.pos:z.end]
 }
 
+// Between returns the buffered bytes between the marks start and end, previously obtained from Pos, which allows extracting sub-ranges of the selection and lookahead. It returns an empty slice if start is larger than end or either is outside the buffered bytes after the start position.
+func (z *Shifter) Between(start, end int) []byte {
+	if start < 0 || end < start || len(z.buf)-z.pos < end {
+		return z.buf[z.pos:z.pos]
+	}
+	return z.buf[z.pos+start : z.pos+end]
+}
+
+// ReadUntilFunc advances the end position until stop returns true for a byte or EOF is reached, and returns the bytes of the selection while collapsing the position to the end like Shift. The byte for which stop returns true is not included.
+func (z *Shifter) ReadUntilFunc(stop func(byte) bool) []byte {
+	for {
+		c := z.Peek(0)
+		if c == 0 && z.end >= len(z.buf) || stop(c) {
+			break
+		}
+		z.end++
+	}
+	return z.Shift()
+}
+
+// Last returns the last byte of the current selection, or zero if the selection is empty.
+func (z *Shifter) Last() byte {
+	if z.end <= z.pos {
+		return 0
+	}
+	return z.buf[z.end-1]
+}
+
+// TrimSelection moves the end position backward over trailing bytes of the selection that are in cutset, which excludes for example trailing whitespace from the token before calling Shift. It does not move before the start position.
+func (z *Shifter) TrimSelection(cutset *Charset) {
+	for z.pos < z.end && cutset[z.buf[z.end-1]] {
+		z.end--
+	}
+}
+
+// Equal returns true when the current selection equals b, without allocating or shifting.
+func (z *Shifter) Equal(b []byte) bool {
+	if z.end-z.pos != len(b) {
+		return false
+	}
+	return string(z.buf[z.pos:z.end]) == string(b)
+}
+
 // Shift returns the bytes of the current selection and collapses the position to the end.
+// Unless IsEOF returns true, the bytes are copied so that they remain valid after subsequent calls to Peek. Use ShiftNoCopy to avoid the copy.
 func (z *Shifter) Shift() []byte {
+	b := z.ShiftNoCopy()
+	if !z.IsEOF() {
+		b = append([]byte{}, b...)
+	}
+	return b
+}
+
+// AppendShift appends the bytes of the current selection to dst, collapses the position to the end and returns the extended slice.
+// This avoids an allocation per token when accumulating tokens, as opposed to Shift.
+func (z *Shifter) AppendShift(dst []byte) []byte {
+	return append(dst, z.ShiftNoCopy()...)
+}
+
+// ShiftNoCopy returns the bytes of the current selection and collapses the position to the end, without copying.
+// Calling Peek may invalidate the returned byte slice, unless IsEOF returns true. When the end position was moved before the start position, it is reset to the start position and an empty slice is returned.
+func (z *Shifter) ShiftNoCopy() []byte {
+	if z.end < z.pos {
+		z.end = z.pos
+	}
 	b := z.buf[z.pos:z.end]
 	z.pos = z.end
 	return b
 }
 
+// ShiftTo writes the bytes of the current selection to w and collapses the position to the end.
+// Unlike ShiftNoCopy, the bytes are consumed by w before a subsequent Peek can invalidate them, and unlike Shift they are not copied.
+// It returns the number of bytes written and an error if occurred, in which case only the written bytes are collapsed.
+func (z *Shifter) ShiftTo(w io.Writer) (int, error) {
+	if z.end < z.pos {
+		z.end = z.pos
+	}
+	n, err := w.Write(z.buf[z.pos:z.end])
+	z.pos += n
+	return n, err
+}
+
+// Pipe repeatedly calls scan, which advances the end position of src, and writes the selection to dst after each call until scan returns false. It is the skeleton of a streaming transformer such as a minifier, where scan may modify the selection in-place.
+// Errors are available through src.Err.
+func Pipe(dst *Writer, src *Shifter, scan func(*Shifter) bool) {
+	for scan(src) {
+		dst.Write(src.ShiftNoCopy())
+	}
+}
+
+// Tokens returns an iterator that returns the tokens produced by scan until scan returns false, after which the iterator keeps returning false.
+// Calling the iterator may invalidate previously returned tokens like Peek does, so they need to be copied unless IsEOF returns true.
+func (z *Shifter) Tokens(scan func(*Shifter) ([]byte, bool)) func() ([]byte, bool) {
+	done := false
+	return func() ([]byte, bool) {
+		if done {
+			return nil, false
+		}
+		tok, ok := scan(z)
+		if !ok {
+			done = true
+			return nil, false
+		}
+		return tok, true
+	}
+}
+
 // Skip collapses the position to the end.
 func (z *Shifter) Skip() {
 	z.pos = z.end
 }
+
+// SkipN advances the end position by n bytes, reading as needed, and collapses the position to the end. It returns the number of bytes skipped, which is less than n when EOF is reached.
+// It skips the buffered bytes before reading more, so the buffer does not grow for large n.
+func (z *Shifter) SkipN(n int) int {
+	m := 0
+	for m < n {
+		if z.end >= len(z.buf) {
+			z.Skip()
+			if z.Peek(0) == 0 && z.end >= len(z.buf) {
+				break
+			}
+		}
+		k := len(z.buf) - z.end
+		if n-m < k {
+			k = n - m
+		}
+		z.end += k
+		m += k
+	}
+	z.Skip()
+	return m
+}
@@ -2,9 +2,16 @@ package buffer // import "github.com/tdewolff/buffer"
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/tdewolff/test"
 )
@@ -43,10 +50,81 @@ func TestShifter(t *testing.T) {
 	test.T(t, z.Err(), nil, "error must be nil just before the end of the buffer, even when it has been past the buffer")
 }
 
+func TestShifterShiftCopy(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum dolor")), 8)
+	z.Move(6)
+	lorem := z.Shift()
+	test.That(t, z.Peek(7) == 'o', "must be 'o' at position 13")
+	test.Bytes(t, lorem, []byte("Lorem "), "shifted bytes must survive a reallocating peek")
+
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum dolor")), 8)
+	z.Move(6)
+	lorem = z.ShiftNoCopy()
+	z.Move(2)
+	z.Peek(0) // reads into the same buffer
+	test.That(t, !bytes.Equal(lorem, []byte("Lorem ")), "bytes shifted without copying must be overwritten")
+
+	z = NewShifter(bytes.NewBufferString("Lorem ipsum"))
+	z.Move(6)
+	b := z.Bytes()
+	test.That(t, &z.Shift()[0] == &b[0], "in-memory buffer must not be copied")
+}
+
+func TestShifterAppendShift(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum dolor sit amet")), 4)
+	var dst []byte
+	for c := z.Peek(0); c != 0; c = z.Peek(0) {
+		if c == ' ' {
+			dst = z.AppendShift(dst)
+			dst = append(dst, ',')
+			z.Move(1)
+			z.Skip()
+		} else {
+			z.Move(1)
+		}
+	}
+	dst = z.AppendShift(dst)
+	test.Bytes(t, dst, []byte("Lorem,ipsum,dolor,sit,amet"), "appended tokens must match")
+	test.That(t, z.Pos() == 0, "position must be collapsed")
+}
+
 func TestShifterSmall(t *testing.T) {
 	s := `abcdefghi`
 	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 4)
 	test.That(t, z.Peek(8) == 'i', "first character must be 'i' at position 8")
+
+	z = NewShifterSize(&chunkReader{r: bytes.NewBufferString(s), n: 2}, 4)
+	z.Move(2)
+	z.Skip()
+	test.That(t, z.Peek(6) == 'i', "must be 'i' at position 8 over short reads")
+	test.That(t, z.Peek(7) == 0, "must yield error at position 9")
+	test.That(t, z.IsEOF(), "must be EOF at position 9")
+}
+
+func TestShifterGrowthFactor(t *testing.T) {
+	defer func(f float64) { GrowthFactor = f }(GrowthFactor)
+	GrowthFactor = 1.5
+
+	s := `abcdefghijklmnopqrstuvwxyz`
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 8)
+	test.That(t, z.Peek(8) == 'i', "must be 'i' at position 8")
+	test.That(t, cap(z.buf) == 12+8, "buffer must grow by 1.5 plus the lookahead")
+
+	w := NewWriter(make([]byte, 0, 8))
+	w.Write([]byte(s[:9]))
+	test.That(t, cap(w.Bytes()) == 12+9, "writer must grow by 1.5 plus the written length")
+
+	GrowthFactor = 0.5
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 8)
+	test.That(t, z.Peek(7) == 'h', "must be 'h' at position 7")
+	z.Feed([]byte("!"))
+	test.That(t, z.Peek(8) == '!', "must be the fed byte at position 8")
+	test.That(t, z.Peek(16) == 'p', "buffer must grow with a factor below 1")
+
+	w = NewWriter(make([]byte, 0, 8))
+	w.Write([]byte(s[:6]))
+	w.Write([]byte(s[6:9]))
+	test.Bytes(t, w.Bytes(), []byte(s[:9]), "writer must not shrink when growing")
 }
 
 func TestShifterRunes(t *testing.T) {
@@ -63,6 +141,570 @@ func TestShifterRunes(t *testing.T) {
 	r, n = z.PeekRune(6)
 	test.That(t, n == 4, "seventh character must be length 4")
 	test.That(t, r == '\U00100000', "seventh character must be rune '\U00100000'")
+
+	// strings.Reader implements io.RuneReader, runes are still decoded from the buffered bytes
+	s := "aæ†\U00100000"
+	z = NewShifterSize(strings.NewReader(s), 4)
+	for _, expected := range s {
+		r, n = z.PeekRune(0)
+		test.That(t, r == expected && n == utf8.RuneLen(expected), "character must be rune", string(expected))
+		z.Move(n)
+	}
+	test.Bytes(t, z.Shift(), []byte(s), "selection must contain all runes")
+}
+
+func TestShifterScanIdentifier(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("_lorem_ipsum42+9dolor sit")), 4)
+	test.That(t, z.ScanIdentifier() == 14, "identifier must span reads")
+	test.Bytes(t, z.Shift(), []byte("_lorem_ipsum42"), "identifier must be selected")
+	test.That(t, z.ScanIdentifier() == 0, "'+' must not start an identifier")
+	z.Move(1)
+	z.Skip()
+	test.That(t, z.ScanIdentifier() == 0, "digit must not start an identifier")
+	z.Move(7)
+	z.Skip()
+	test.That(t, z.ScanIdentifier() == 3, "identifier must stop at EOF")
+	test.Bytes(t, z.Shift(), []byte("sit"), "identifier at EOF must be selected")
+}
+
+func TestShifterScanField(t *testing.T) {
+	s := "lorem,\"ipsum, dolor\",\"sit \"\"amet\"\"\"\r\n\"multi\nline\",,last\n\"\"\nx,\"unterminated"
+	z := NewShifterSize(&chunkReader{r: bytes.NewBufferString(s), n: 3}, 4)
+	var fieldTests = []struct {
+		field  string
+		record bool
+		err    error
+	}{
+		{"lorem", false, nil},
+		{"ipsum, dolor", false, nil},
+		{"sit \"amet\"", true, nil},
+		{"multi\nline", false, nil},
+		{"", false, nil},
+		{"last", true, nil},
+		{"", true, nil},
+		{"x", false, nil},
+		{"unterminated", true, io.ErrUnexpectedEOF},
+		{"", true, io.EOF},
+	}
+	for _, tt := range fieldTests {
+		field, record, err := z.ScanField(',', '"')
+		test.T(t, string(field), tt.field, "field must match")
+		test.That(t, record == tt.record, "record terminator must match for", tt.field)
+		test.T(t, err, tt.err, "error must match for", tt.field)
+	}
+
+	z = NewShifter(bytes.NewBufferString("a;b"))
+	field, record, err := z.ScanField(';', '\'')
+	test.That(t, string(field) == "a" && !record && err == nil, "first field must be 'a'")
+	field, record, err = z.ScanField(';', '\'')
+	test.That(t, string(field) == "b" && record && err == nil, "last field must end the record at EOF")
+
+	var trailingTests = []struct {
+		s      string
+		fields []string
+	}{
+		{"a,", []string{"a", ""}},
+		{"a,\n", []string{"a", ""}},
+		{"a,,", []string{"a", "", ""}},
+	}
+	for _, tt := range trailingTests {
+		z = NewShifterSize(&chunkReader{r: bytes.NewBufferString(tt.s), n: 1}, 4)
+		for i, expected := range tt.fields {
+			field, record, err = z.ScanField(',', '"')
+			test.T(t, string(field), expected, "field must match for", tt.s)
+			test.T(t, err, nil, "error must be nil for", tt.s)
+			test.That(t, record == (i == len(tt.fields)-1), "only the last field must end the record for", tt.s)
+		}
+		_, _, err = z.ScanField(',', '"')
+		test.T(t, err, io.EOF, "error must be EOF after the trailing field for", tt.s)
+	}
+
+	var quoteTests = []struct {
+		s     string
+		field string
+	}{
+		{"\"a\"x,b", "a"},
+		{"\"a\" ,b", "a"},
+		{"a\"x,b", "a\"x"},
+		{"a\",b", "a\""},
+	}
+	for _, tt := range quoteTests {
+		z = NewShifterSize(&chunkReader{r: bytes.NewBufferString(tt.s), n: 1}, 4)
+		field, record, err = z.ScanField(',', '"')
+		test.T(t, string(field), tt.field, "field must match for", tt.s)
+		test.T(t, err, ErrQuote, "error must be ErrQuote for", tt.s)
+		test.That(t, !record, "field must not end the record for", tt.s)
+		field, record, err = z.ScanField(',', '"')
+		test.That(t, string(field) == "b" && record && err == nil, "must continue after the invalid field for", tt.s)
+	}
+}
+
+func TestShifterPeekLine(t *testing.T) {
+	long := bytes.Repeat([]byte("Lorem ipsum "), 500)
+	s := string(long) + "\nsecond\r\n\nlast"
+	z := NewShifter(test.NewPlainReader(bytes.NewBufferString(s)))
+	line, ok := z.PeekLine()
+	test.That(t, ok, "newline must be found")
+	test.Bytes(t, line, long, "line longer than the buffer must be returned")
+	test.That(t, z.Pos() == 0, "position must be unaffected")
+
+	z.Move(len(long) + 1)
+	z.Skip()
+	line, ok = z.PeekLine()
+	test.That(t, ok, "newline must be found")
+	test.Bytes(t, line, []byte("second"), "CRLF must be excluded")
+
+	z.Move(8)
+	line, ok = z.PeekLine()
+	test.That(t, ok, "newline must be found")
+	test.Bytes(t, line, []byte(""), "empty line must be empty")
+
+	z.Move(1)
+	line, ok = z.PeekLine()
+	test.That(t, !ok, "newline must not be found at EOF")
+	test.Bytes(t, line, []byte("last"), "final line must be returned")
+
+	defer func(n int) { MaxBuf = n }(MaxBuf)
+	MaxBuf = 16
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 8)
+	line, ok = z.PeekLine()
+	test.That(t, !ok, "newline must not be found beyond MaxBuf")
+	test.Bytes(t, line, long[:16], "line must be cut at MaxBuf")
+	test.T(t, z.Err(), ErrExceeded, "error must be ErrExceeded")
+	z.Move(12)
+	z.Skip()
+	line, ok = z.PeekLine()
+	test.That(t, !ok, "newline must not be found after ErrExceeded")
+	test.Bytes(t, line, long[12:len(z.buf)], "only buffered bytes must be returned after ErrExceeded")
+	test.T(t, z.Err(), ErrExceeded, "error must be kept")
+}
+
+func TestShifterRuneAhead(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("aæ\xff†b\U00100000")), 4)
+	z.Move(1)
+	r, n, i := z.RuneAhead(0)
+	test.That(t, r == 'æ' && n == 2 && i == 0, "first rune must be 'æ' at offset 0")
+	r, n, i = z.RuneAhead(1)
+	test.That(t, r == utf8.RuneError && n == 1 && i == 2, "second rune must be invalid at offset 2")
+	r, n, i = z.RuneAhead(2)
+	test.That(t, r == '†' && n == 3 && i == 3, "third rune must be '†' at offset 3")
+	r, n, i = z.RuneAhead(4)
+	test.That(t, r == '\U00100000' && n == 4 && i == 7, "fifth rune must be '\U00100000' at offset 7")
+	test.Bytes(t, z.Bytes(), []byte("a"), "selection must be unaffected")
+	r, _, i = z.RuneAhead(5)
+	test.That(t, r == 0 && i == 11, "sixth rune must yield EOF")
+}
+
+func TestShifterScanString(t *testing.T) {
+	var stringTests = []struct {
+		s          string
+		n          int
+		terminated bool
+	}{
+		{`"abc" def`, 5, true},
+		{`'abc' def`, 0, false},
+		{`"a\"bc" def`, 7, true},
+		{`"abc\\" def`, 7, true},
+		{`"a\\\"bc" def`, 9, true},
+		{`"abc def`, 8, false},
+		{`"abc\`, 5, false},
+		{`"lorem ipsum dolor sit amet"`, 28, true},
+	}
+	for _, tt := range stringTests {
+		z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString(tt.s)), 4)
+		n, terminated := z.ScanString('"')
+		test.That(t, n == tt.n, "length must match for", tt.s)
+		test.That(t, terminated == tt.terminated, "termination must match for", tt.s)
+		test.Bytes(t, z.Bytes(), []byte(tt.s[:n]), "selection must match for", tt.s)
+	}
+}
+
+func TestShifterCountLines(t *testing.T) {
+	z := NewShifter(test.NewPlainReader(bytes.NewBufferString("lorem\nipsum\r\ndolor\n\nsit")))
+	z.Move(5)
+	test.That(t, z.CountLines(0, z.Pos()) == 0, "selection must span zero lines")
+	z.Move(1)
+	test.That(t, z.CountLines(0, z.Pos()) == 1, "selection must span one line")
+	z.Move(7)
+	test.That(t, z.CountLines(0, z.Pos()) == 2, "CRLF must count as one line")
+	test.That(t, z.CountLines(6, z.Pos()) == 1, "range must be relative to the start position")
+	z.Move(7)
+	test.That(t, z.CountLines(0, z.Pos()) == 4, "selection must span several lines")
+	test.That(t, z.CountLines(-5, 100) == 4, "range must be clamped to the buffered bytes")
+	test.That(t, z.CountLines(4, 2) == 0, "empty range must span zero lines")
+	z.Move(2)
+	z.Skip()
+	test.That(t, z.CountLines(0, 3) == 0, "range must start at the start position")
+}
+
+func TestShifterScanJSONNumber(t *testing.T) {
+	var numberTests = []struct {
+		s     string
+		n     int
+		valid bool
+	}{
+		{"0", 1, true},
+		{"-0", 2, true},
+		{"42,", 2, true},
+		{"1e10", 4, true},
+		{"3.14E-2]", 7, true},
+		{"-12.5e+3 ", 8, true},
+		{"1234567890.0987654321", 21, true},
+		{"01", 2, false},
+		{"1.", 2, false},
+		{"1.e5", 2, false},
+		{"1e", 2, false},
+		{"1e+", 3, false},
+		{".5", 0, false},
+		{"-", 0, false},
+		{"+1", 0, false},
+	}
+	for _, tt := range numberTests {
+		z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString(tt.s)), 4)
+		n, valid := z.ScanJSONNumber()
+		test.That(t, n == tt.n, "length must match for", tt.s)
+		test.That(t, valid == tt.valid, "validity must match for", tt.s)
+		test.Bytes(t, z.Bytes(), []byte(tt.s[:n]), "selection must match for", tt.s)
+	}
+}
+
+func TestShifterSkipComment(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("// lorem ipsum\nx")), 4)
+	test.That(t, !z.SkipLineComment([]byte("#")), "must not skip without prefix")
+	test.That(t, z.SkipLineComment([]byte("//")), "must skip line comment")
+	test.Bytes(t, z.Shift(), []byte("// lorem ipsum"), "line comment must stop before the newline")
+	test.That(t, z.Peek(0) == '\n', "must be at the newline")
+
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString("x # lorem ipsum")), 4)
+	z.Move(2)
+	z.Skip()
+	test.That(t, z.SkipLineComment([]byte("#")), "must skip line comment at EOF")
+	test.Bytes(t, z.Shift(), []byte("# lorem ipsum"), "line comment must stop at EOF")
+
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString("/* lorem /* ipsum */ dolor */")), 4)
+	test.That(t, !z.SkipBlockComment([]byte("<!--"), []byte("-->")), "must not skip without open")
+	test.That(t, z.SkipBlockComment([]byte("/*"), []byte("*/")), "must skip block comment")
+	test.Bytes(t, z.Shift(), []byte("/* lorem /* ipsum */"), "block comments must not nest")
+
+	z = NewShifterSize(&chunkReader{r: bytes.NewBufferString("/* lorem */ ipsum"), n: 1}, 4)
+	test.That(t, z.SkipBlockComment([]byte("/*"), []byte("*/")), "must skip block comment over short reads")
+	test.Bytes(t, z.Shift(), []byte("/* lorem */"), "block comment must end after close")
+
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString("/* lorem ipsum *")), 4)
+	test.That(t, !z.SkipBlockComment([]byte("/*"), []byte("*/")), "unterminated block comment must return false")
+	test.Bytes(t, z.Shift(), []byte("/* lorem ipsum *"), "unterminated block comment must be consumed till EOF")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+}
+
+func TestShifterScanDigits(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("1aF3e9bC;")), 4)
+	v, n, ok := z.ScanHex()
+	test.That(t, v == 0x1af3e9bc && n == 8 && ok, "hexadecimal digits must be parsed over reads")
+	test.Bytes(t, z.Shift(), []byte("1aF3e9bC"), "hexadecimal digits must be selected")
+
+	v, n, ok = z.ScanHex()
+	test.That(t, v == 0 && n == 0 && ok, "empty run must yield zero digits")
+
+	z = NewShifter(bytes.NewBufferString("ffffffffffffffff 10000000000000000 0x10"))
+	v, n, ok = z.ScanHex()
+	test.That(t, v == math.MaxUint64 && n == 16 && ok, "maximum value must not overflow")
+	z.Move(1)
+	v, n, ok = z.ScanHex()
+	test.That(t, v == math.MaxUint64 && n == 17 && !ok, "overflow must be detected and consume all digits")
+	z.Move(1)
+	v, n, ok = z.ScanHex()
+	test.That(t, v == 0 && n == 1 && ok, "prefix must not be consumed")
+
+	z = NewShifter(bytes.NewBufferString("0755819"))
+	v, n, ok = z.ScanDigits(8)
+	test.That(t, v == 0755 && n == 4 && ok, "octal digits must stop at an invalid digit")
+	v, n, ok = z.ScanDigits(10)
+	test.That(t, v == 819 && n == 3 && ok, "decimal digits must be parsed")
+}
+
+func TestShifterSnapshot(t *testing.T) {
+	s := `Lorem ipsum dolor sit amet`
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 4)
+	move := func(n int) {
+		z.Peek(n - 1)
+		z.Move(n)
+	}
+	move(6)
+	z.Skip()
+	move(2)
+	z.Peek(16) // buffer ahead, as reading would discard the shifted bytes
+	state := z.Snapshot()
+
+	move(4)
+	test.Bytes(t, z.Shift(), []byte("ipsum "), "first token must be 'ipsum '")
+	move(6)
+	test.Bytes(t, z.Shift(), []byte("dolor "), "second token must be 'dolor '")
+
+	z.Restore(state)
+	test.That(t, z.Pos() == 2, "position must be restored")
+	test.Bytes(t, z.Bytes(), []byte("ip"), "selection must be restored")
+	move(4)
+	test.Bytes(t, z.Shift(), []byte("ipsum "), "first token must be 'ipsum ' after restoring")
+}
+
+func TestShifterBetween(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("key=value; rest")), 4)
+	z.Peek(11)
+	z.Move(3)
+	eq := z.Pos()
+	z.Move(6)
+	end := z.Pos()
+	test.Bytes(t, z.Between(0, eq), []byte("key"), "first sub-range must be the key")
+	test.Bytes(t, z.Between(eq+1, end), []byte("value"), "second sub-range must be the value")
+	test.Bytes(t, z.Between(end, end), []byte{}, "empty range must be empty")
+	test.Bytes(t, z.Between(end, eq), []byte{}, "reversed range must be empty")
+	test.Bytes(t, z.Between(-1, eq), []byte{}, "range before the start position must be empty")
+	test.Bytes(t, z.Between(eq, 100), []byte{}, "range beyond the buffered bytes must be empty")
+	z.Skip()
+	test.Bytes(t, z.Between(0, 2), []byte("; "), "marks must be relative to the start position")
+}
+
+func TestShifterEndBeforeStart(t *testing.T) {
+	z := NewShifter(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum")))
+	z.Move(6)
+	z.Skip()
+	z.Move(-2)
+	test.Bytes(t, z.Bytes(), []byte{}, "selection must be empty when the end is before the start")
+	test.Bytes(t, z.Shift(), []byte{}, "shift must return an empty slice when the end is before the start")
+	test.That(t, z.Pos() == 0, "end must be reset to the start")
+	z.Move(5)
+	test.Bytes(t, z.Shift(), []byte("ipsum"), "selection must continue from the start")
+}
+
+func TestShifterSkipUntilPattern(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("lorem ipsum --> dolor")), 4)
+	z.Move(2)
+	test.That(t, z.SkipUntilPattern([]byte("-->")), "pattern must be found across reads")
+	test.That(t, z.Pos() == 0, "position must be collapsed")
+	test.That(t, z.Peek(0) == '-', "end position must be at the start of the pattern")
+	test.That(t, z.SkipUntilPattern([]byte("-->")), "pattern must be found at the end position")
+	test.That(t, z.Peek(0) == '-', "end position must not move when at the pattern")
+	test.That(t, z.SkipUntilPattern([]byte{}), "empty pattern must be found")
+
+	s := strings.Repeat("lorem ipsum ", 100) + "*/ dolor"
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 16)
+	test.That(t, z.SkipUntilPattern([]byte("*/")), "pattern must be found after many reads")
+	test.That(t, z.Cap() < 100, "buffer must not grow with the skipped bytes")
+	z.Move(2)
+	test.Bytes(t, z.Shift(), []byte("*/"), "selection must be the pattern")
+	test.That(t, !z.SkipUntilPattern([]byte("*/")), "absent pattern must not be found")
+	test.That(t, z.Peek(0) == 0, "must skip till EOF")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+}
+
+func TestShifterTrimSelection(t *testing.T) {
+	space := MakeCharset(" \t\n")
+	z := NewShifter(test.NewPlainReader(bytes.NewBufferString("lorem \t\n ipsum   ")))
+	z.Move(9)
+	z.TrimSelection(&space)
+	test.That(t, z.Pos() == 5, "end position must move back over trailing spaces")
+	test.Bytes(t, z.Shift(), []byte("lorem"), "selection must exclude trailing spaces")
+	z.Move(4)
+	z.Skip()
+	z.TrimSelection(&space)
+	test.That(t, z.Pos() == 0, "empty selection must stay empty")
+	z.Move(5)
+	z.Skip()
+	z.Move(3)
+	z.TrimSelection(&space)
+	test.Bytes(t, z.Shift(), []byte{}, "selection of only spaces must be trimmed to empty")
+	test.That(t, z.Peek(0) == ' ', "must not move before the start position")
+}
+
+func TestShifterSeek(t *testing.T) {
+	z := NewShifter(bytes.NewBufferString("Lorem ipsum"))
+	z.Move(5)
+	z.Skip()
+	test.T(t, z.Seek(6), nil, "error")
+	z.Move(5)
+	test.Bytes(t, z.Shift(), []byte("ipsum"), "selection must start at the seeked offset")
+	test.T(t, z.Seek(0), nil, "error")
+	z.Move(5)
+	test.Bytes(t, z.Shift(), []byte("Lorem"), "must seek backward")
+	test.T(t, z.Seek(11), nil, "error")
+	test.That(t, z.Peek(0) == 0, "must seek to EOF")
+	test.T(t, z.Seek(12), ErrSeek, "must not seek beyond EOF")
+	test.T(t, z.Seek(-1), ErrSeek, "must not seek before the start")
+
+	z = NewShifter(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum")))
+	test.T(t, z.Seek(0), ErrSeek, "must not seek a streaming source")
+}
+
+func TestShifterAdvance(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum")), 4)
+	test.Bytes(t, z.Advance(2), []byte("Lo"), "must advance within the buffer")
+	test.Bytes(t, z.Advance(5), []byte("rem i"), "must advance past a reallocation")
+	test.Bytes(t, z.Bytes(), []byte("Lorem i"), "selection must include the advanced bytes")
+	test.Bytes(t, z.Advance(0), []byte{}, "must not advance zero bytes")
+	test.Bytes(t, z.Advance(10), []byte("psum"), "must return fewer bytes at EOF")
+	test.That(t, z.Pos() == 11, "must not advance beyond EOF")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+}
+
+func TestShifterCopyN(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum")), 4)
+	dst := make([]byte, 8)
+	n, err := z.CopyN(dst, 6)
+	test.T(t, err, nil, "error")
+	test.That(t, n == 6, "must copy 6 bytes across a reallocation")
+	test.Bytes(t, dst[:n], []byte("Lorem "), "copied bytes must match")
+	test.That(t, z.Pos() == 6, "must advance the end position")
+
+	n, err = z.CopyN(dst, 8)
+	test.T(t, err, io.EOF, "error must be EOF when copying beyond the end")
+	test.That(t, n == 5, "must copy the remaining 5 bytes")
+	test.Bytes(t, dst[:n], []byte("ipsum"), "copied bytes must match")
+	test.That(t, z.Pos() == 11, "must advance to EOF")
+}
+
+func TestShifterSkipN(t *testing.T) {
+	s := strings.Repeat("Lorem ipsum ", 10)
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 4)
+	z.Move(2)
+	test.That(t, z.SkipN(60) == 60, "must skip more than the buffered bytes")
+	test.That(t, z.Pos() == 0, "position must be collapsed")
+	test.That(t, cap(z.buf) < 60, "buffer must not grow to the skipped length")
+	test.That(t, z.Peek(0) == 'r', "must be 'r' at position 62")
+
+	z.Move(1)
+	test.That(t, z.SkipN(100) == 57, "must skip fewer bytes at EOF")
+	test.That(t, z.Pos() == 0, "position must be collapsed at EOF")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+	test.That(t, z.SkipN(1) == 0, "must not skip beyond EOF")
+}
+
+func TestShifterRewind(t *testing.T) {
+	z1 := NewShifter(bytes.NewBufferString("Lorem ipsum"))
+	z2 := NewShifter(bytes.NewBufferString("Lorem ipsum"))
+	z1.Move(8)
+	z2.Move(8)
+	z1.MoveTo(3)
+	z2.Rewind(3)
+	test.That(t, z1.Pos() == z2.Pos(), "positions must be equal")
+	test.Bytes(t, z1.Bytes(), z2.Bytes(), "selections must be equal")
+	test.That(t, z1.Peek(0) == z2.Peek(0), "peeks must be equal")
+}
+
+func TestShifterBack(t *testing.T) {
+	z := NewShifter(bytes.NewBufferString("Lorem ipsum"))
+	z.Move(6)
+	z.Skip()
+	z.Move(5)
+	z.Back(2)
+	test.Bytes(t, z.Bytes(), []byte("ips"), "must back up within the selection")
+	test.That(t, z.Peek(0) == 'u', "must be 'u' after backing up")
+	z.Back(5)
+	test.That(t, z.Pos() == 0, "must not back up before the start position")
+	test.Bytes(t, z.Bytes(), []byte(""), "selection must be empty")
+}
+
+func TestShifterMoveRune(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("aæ\xff†\U00100000")), 3)
+	test.That(t, z.MoveRune() == 1, "first rune must be length 1")
+	test.That(t, z.MoveRune() == 2, "second rune must be length 2")
+	test.That(t, z.MoveRune() == 1, "invalid rune must be length 1")
+	test.That(t, z.MoveRune() == 3, "fourth rune must be length 3")
+	test.That(t, z.MoveRune() == 4, "fifth rune must be length 4")
+	test.Bytes(t, z.Bytes(), []byte("aæ\xff†\U00100000"), "selection must contain all runes")
+	test.That(t, z.MoveRune() == 0, "must not move at EOF")
+	test.That(t, z.Pos() == 11, "position must not move at EOF")
+}
+
+// pipeShifter returns a Shifter over a pipe to which only s is written, so that reading beyond s blocks
+func pipeShifter(s string) (*Shifter, *io.PipeWriter) {
+	pr, pw := io.Pipe()
+	go pw.Write([]byte(s))
+	return NewShifter(pr), pw
+}
+
+func nonBlocking(t *testing.T, f func(), msg string) {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal(msg)
+	}
+}
+
+func TestShifterMoveRunePipe(t *testing.T) {
+	for _, s := range []string{"a", "æ", "􀀀"} {
+		z, pw := pipeShifter(s)
+		nonBlocking(t, func() {
+			test.That(t, z.MoveRune() == len(s), "rune must be moved over for", s)
+		}, "MoveRune must not read beyond the rune")
+		pw.Close()
+	}
+}
+
+func TestShifterRuneAheadPipe(t *testing.T) {
+	z, pw := pipeShifter("aæ")
+	nonBlocking(t, func() {
+		r, n, i := z.RuneAhead(1)
+		test.That(t, r == 'æ' && n == 2 && i == 1, "second rune must be 'æ' at offset 1")
+	}, "RuneAhead must not read beyond the rune")
+	pw.Close()
+}
+
+func TestShifterLast(t *testing.T) {
+	z := NewShifter(bytes.NewBufferString("Lorem ipsum"))
+	z.Move(6)
+	z.Skip()
+	test.That(t, z.Last() == 0, "empty selection must yield zero")
+	z.Move(1)
+	test.That(t, z.Last() == 'i', "single-byte selection must yield 'i'")
+	z.Move(3)
+	test.That(t, z.Last() == 'u', "multi-byte selection must yield 'u'")
+}
+
+func TestShifterEqual(t *testing.T) {
+	z := NewShifter(bytes.NewBufferString("Lorem ipsum"))
+	test.That(t, z.Equal([]byte{}), "empty selection must equal empty bytes")
+	z.Move(5)
+	test.That(t, z.Equal([]byte("Lorem")), "selection must equal 'Lorem'")
+	test.That(t, !z.Equal([]byte("lorem")), "selection must not equal 'lorem'")
+	test.That(t, !z.Equal([]byte("Lore")), "selection must not equal shorter bytes")
+	test.That(t, !z.Equal([]byte("Lorem ")), "selection must not equal longer bytes")
+	test.That(t, z.Pos() == 5, "position must be unaffected")
+}
+
+func TestShifterMoveToEnd(t *testing.T) {
+	s := `Lorem ipsum dolor sit amet, consectetur adipiscing elit.`
+	z := NewShifterSize(&chunkReader{r: bytes.NewBufferString(s), n: 5}, 4)
+	test.That(t, z.Peek(7) == 'p', "must be 'p' at position 7")
+	z.Move(6)
+	z.Skip()
+	z.Move(2)
+	z.MoveToEnd()
+	test.That(t, z.IsEOF(), "must be EOF after moving to the end")
+	test.That(t, z.Pos() == len(s)-6, "position must equal the remaining length")
+	test.Bytes(t, z.Shift(), []byte(s[6:]), "selection must contain the remainder")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+
+	z = NewShifter(bytes.NewBufferString(s))
+	z.MoveToEnd()
+	test.That(t, z.Pos() == len(s), "position must equal the length for in-memory buffers")
+}
+
+func TestShifterReadUntilFunc(t *testing.T) {
+	isPunct := func(c byte) bool {
+		return c == ',' || c == '.'
+	}
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum, dolor sit amet")), 4)
+	test.Bytes(t, z.ReadUntilFunc(isPunct), []byte("Lorem ipsum"), "must stop before ','")
+	test.That(t, z.Peek(0) == ',', "stop byte must not be consumed")
+	test.Bytes(t, z.ReadUntilFunc(isPunct), []byte(""), "must return nothing when stopping immediately")
+	z.Move(1)
+	test.Bytes(t, z.ReadUntilFunc(isPunct), []byte(", dolor sit amet"), "must read till EOF")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
 }
 
 func TestShifterZeroLen(t *testing.T) {
@@ -76,6 +718,750 @@ func TestShifterEmptyReader(t *testing.T) {
 	test.That(t, z.IsEOF(), "empty reader must return EOF")
 }
 
+func TestShifterShiftTo(t *testing.T) {
+	s := `Lorem ipsum dolor sit amet, consectetur adipiscing elit.`
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 4)
+	w := NewWriter(nil)
+	for z.Peek(0) != 0 {
+		for c := z.Peek(0); c != ' ' && c != 0; c = z.Peek(0) {
+			z.Move(1)
+		}
+		if z.Peek(0) == ' ' {
+			z.Move(1)
+		}
+		n, err := z.ShiftTo(w)
+		test.T(t, err, nil, "error must be nil")
+		test.That(t, z.Pos() == 0, "after shifting position must be 0")
+		test.That(t, n > 0, "shift must write bytes")
+	}
+	test.Bytes(t, w.Bytes(), []byte(s), "written tokens must match the input")
+}
+
+func TestShifterPeekLimited(t *testing.T) {
+	s := `abcdefghijklm`
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 4)
+	z.Move(2)
+	test.That(t, z.PeekLimited(5, 8) == 'h', "must be 'h' just under the limit")
+	test.T(t, z.Err(), nil, "error must be nil under the limit")
+	test.That(t, z.PeekLimited(6, 8) == 0, "must yield error just over the limit")
+	test.T(t, z.Err(), ErrExceeded, "error must be ErrExceeded over the limit")
+	z.Move(4)
+	z.Skip()
+	test.That(t, z.Peek(0) == 'g', "buffered bytes must still be peekable after ErrExceeded")
+	test.That(t, z.Peek(len(z.buf)-z.end) == 0, "must not read after ErrExceeded, even after shifting")
+	test.T(t, z.Err(), ErrExceeded, "error must be kept")
+
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 16)
+	z.Move(2)
+	z.Skip()
+	test.That(t, z.PeekLimited(7, 8) == 'j', "limit must be relative to the start position")
+	test.T(t, z.Err(), nil, "error must be nil under the limit")
+
+	z = NewShifter(bytes.NewBufferString(s))
+	test.That(t, z.PeekLimited(12, 8) == 'm', "limit must not apply to in-memory buffers")
+}
+
+func TestShifterResetBytes(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum")), 4)
+	z.Move(6)
+	z.Skip()
+	z.Move(2)
+	z.ResetBytes([]byte("dolor sit"))
+	test.That(t, z.Pos() == 0, "end position must be reset")
+	test.That(t, z.IsEOF(), "must be EOF for in-memory input")
+	test.That(t, z.Peek(4) == 'r', "must be 'r' at position 4")
+	z.Move(5)
+	test.Bytes(t, z.Shift(), []byte("dolor"), "first token must be 'dolor'")
+	z.MoveToEnd()
+	test.Bytes(t, z.Shift(), []byte(" sit"), "second token must be ' sit'")
+	test.That(t, z.Peek(0) == 0, "must yield EOF at the end")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+}
+
+func TestShifterClone(t *testing.T) {
+	s := `Lorem ipsum dolor`
+	z := NewShifter(bytes.NewBufferString(s))
+	z.Move(6)
+	z.Skip()
+	z.Move(2)
+
+	c := z.Clone()
+	test.That(t, c.Pos() == 2, "clone must keep the position")
+	test.Bytes(t, c.Bytes(), []byte("ip"), "clone must keep the selection")
+	c.Move(3)
+	test.Bytes(t, c.Shift(), []byte("ipsum"), "clone must advance independently")
+	test.That(t, c.Peek(1) == 'd', "clone must peek after shifting")
+
+	test.That(t, z.Pos() == 2, "original position must be unaffected")
+	test.Bytes(t, z.Bytes(), []byte("ip"), "original selection must be unaffected")
+	test.That(t, z.Peek(0) == 's', "original must peek unaffected")
+
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 8)
+	z.Move(2)
+	c = z.Clone()
+	test.That(t, c.IsEOF(), "streaming clone must be detached")
+	test.That(t, c.Peek(5) == 'p', "streaming clone must peek buffered bytes")
+	test.That(t, c.Peek(6) == 0, "streaming clone must not read beyond the buffered bytes")
+	c.Move(6)
+	test.T(t, c.Err(), io.EOF, "streaming clone must return EOF beyond the buffered bytes")
+	test.That(t, z.Peek(6) == 's', "original must still read from the io.Reader")
+	test.T(t, z.Err(), nil, "original error must be nil")
+
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString("abcdef")), 64)
+	z.MoveToEnd()
+	z.MoveTo(2)
+	test.That(t, z.IsEOF() && z.Cap() > 6, "buffer must have spare capacity")
+	c = z.Clone()
+	c.Pushback([]byte("XY"))
+	c2 := z.Clone()
+	c2.Feed([]byte("Z"))
+	z.Feed([]byte("W"))
+	test.That(t, z.Peek(0) == 'c', "original must be unaffected by pushing back on the clone")
+	c.MoveToEnd()
+	c2.MoveToEnd()
+	z.MoveToEnd()
+	test.Bytes(t, c.Bytes(), []byte("abXYcdef"), "clone must see its pushed back bytes")
+	test.Bytes(t, c2.Bytes(), []byte("abcdefZ"), "clone must see its fed bytes")
+	test.Bytes(t, z.Bytes(), []byte("abcdefW"), "original must see its fed bytes only")
+}
+
+func TestPipe(t *testing.T) {
+	s := `Lorem ipsum dolor sit amet, consectetur adipiscing elit.`
+	z := NewShifterSize(&chunkReader{r: bytes.NewBufferString(s), n: 5}, 4)
+	w := NewWriter(nil)
+	Pipe(w, z, func(z *Shifter) bool {
+		for c := z.Peek(0); c != 0; c = z.Peek(0) {
+			z.Move(1)
+			if c == ' ' {
+				break
+			}
+		}
+		b := z.Bytes()
+		for i, c := range b {
+			if 'a' <= c && c <= 'z' {
+				b[i] = c - 'a' + 'A'
+			}
+		}
+		return len(b) > 0
+	})
+	test.Bytes(t, w.Bytes(), bytes.ToUpper([]byte(s)), "output must be uppercased")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+}
+
+func TestShifterTokens(t *testing.T) {
+	z := NewShifterSize(&chunkReader{r: bytes.NewBufferString("  Lorem ipsum\tdolor\n sit amet "), n: 3}, 4)
+	next := z.Tokens(func(z *Shifter) ([]byte, bool) {
+		for c := z.Peek(0); c == ' ' || c == '\t' || c == '\n'; c = z.Peek(0) {
+			z.Move(1)
+		}
+		z.Skip()
+		for c := z.Peek(0); c != 0 && c != ' ' && c != '\t' && c != '\n'; c = z.Peek(0) {
+			z.Move(1)
+		}
+		tok := z.Shift()
+		return tok, len(tok) > 0
+	})
+
+	tokens := []string{}
+	for tok, ok := next(); ok; tok, ok = next() {
+		tokens = append(tokens, string(tok))
+	}
+	test.T(t, tokens, []string{"Lorem", "ipsum", "dolor", "sit", "amet"}, "tokens must be split by whitespace")
+	_, ok := next()
+	test.That(t, !ok, "iterator must remain done")
+}
+
+type chunkReader struct {
+	r     io.Reader
+	n     int
+	reads int
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	r.reads++
+	if len(b) > r.n {
+		b = b[:r.n]
+	}
+	return r.r.Read(b)
+}
+
+func TestShifterTryPeek(t *testing.T) {
+	r := &chunkReader{r: bytes.NewBufferString("Lorem ipsum"), n: 2}
+	z := NewShifterSize(r, 4)
+	reads := r.reads
+	c, ok := z.TryPeek(5)
+	test.That(t, !ok && c == 0, "byte must not be available after a single read")
+	test.That(t, r.reads == reads+1, "must read once")
+	test.T(t, z.Err(), nil, "error must be nil after a short read")
+	c, ok = z.TryPeek(5)
+	test.That(t, ok && c == ' ', "must be ' ' at position 5 after another read")
+	test.That(t, r.reads == reads+2, "must read once per call")
+	c, ok = z.TryPeek(1)
+	test.That(t, ok && c == 'o', "must be 'o' at position 1 without reading")
+	test.That(t, r.reads == reads+2, "must not read buffered bytes")
+
+	z.Move(10)
+	for i := 0; i < 3; i++ {
+		c, ok = z.TryPeek(0)
+	}
+	test.That(t, ok && c == 'm', "must be 'm' at position 10")
+	_, ok = z.TryPeek(1)
+	test.That(t, !ok, "byte beyond the end must not be available")
+	test.That(t, z.IsEOF(), "must be EOF")
+}
+
+func TestShifterFillBuffer(t *testing.T) {
+	r := &chunkReader{r: bytes.NewBufferString("Lorem ipsum dolor"), n: 2}
+	z := NewShifterSize(r, 4)
+	z.Move(1)
+	test.T(t, z.FillBuffer(9), nil, "error must be nil when enough bytes are buffered")
+	test.That(t, len(z.buf)-z.end >= 9, "at least 9 bytes must be buffered")
+	test.That(t, z.Bytes()[0] == 'L', "selection must be unaffected")
+
+	reads := r.reads
+	test.T(t, z.FillBuffer(4), nil, "error must be nil when enough bytes are buffered")
+	test.That(t, r.reads == reads, "must not read when enough bytes are buffered")
+	test.That(t, z.Peek(8) == 'u', "must be 'u' at position 9")
+	test.That(t, r.reads == reads, "must not read when peeking buffered bytes")
+
+	test.T(t, z.FillBuffer(20), io.EOF, "error must be EOF when not enough bytes are available")
+	test.That(t, z.Peek(15) == 'r', "must be 'r' at position 16")
+}
+
+func TestShifterShrinkBuffer(t *testing.T) {
+	s := bytes.Repeat([]byte("Lorem ipsum "), 1000)
+	z := NewShifter(test.NewPlainReader(bytes.NewBuffer(s)))
+	test.That(t, z.Peek(9996) == 'L', "must be 'L' at position 9996")
+	test.That(t, cap(z.buf) > defaultBufSize, "buffer must have grown")
+
+	z.MoveTo(9996)
+	z.Shift()
+	z.Move(2)
+	z.ShrinkBuffer()
+	test.That(t, cap(z.buf) == defaultBufSize, "buffer must have shrunk")
+	test.Bytes(t, z.Bytes(), []byte("Lo"), "selection must be unaffected")
+	test.That(t, z.Peek(0) == 'r', "must be 'r' after shrinking")
+	test.That(t, z.Peek(2000) == 'm', "must read after shrinking")
+
+	z = NewShifter(bytes.NewBuffer(s))
+	z.ShrinkBuffer()
+	test.That(t, len(z.buf) == len(s), "in-memory buffer must not shrink")
+}
+
+func TestShifterScratch(t *testing.T) {
+	unescape := func(z *Shifter) []byte {
+		n, _ := z.ScanString('"')
+		b := z.Shift()[1 : n-1]
+		w := z.Scratch()
+		for i := 0; i < len(b); i++ {
+			if b[i] == '\\' && i+1 < len(b) {
+				i++
+			}
+			w.Write(b[i : i+1])
+		}
+		z.Move(1)
+		z.Skip()
+		return w.Bytes()
+	}
+
+	z := NewShifter(test.NewPlainReader(bytes.NewBufferString(`"lorem \"ipsum\"" "dolor\\sit"`)))
+	test.Bytes(t, unescape(z), []byte(`lorem "ipsum"`), "first string must be unescaped")
+	b := z.Scratch().Bytes()
+	test.That(t, len(b) == 0, "scratch buffer must be reset")
+	test.Bytes(t, unescape(z), []byte(`dolor\sit`), "second string must be unescaped")
+	test.That(t, cap(z.scratch.buf) == cap(b) && &z.scratch.buf[:1][0] == &b[:1][0], "second string must reuse the scratch buffer")
+}
+
+func TestShifterOnRealloc(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum dolor")), 4)
+	z.Move(2)
+	z.Skip()
+	calls := 0
+	z.OnRealloc(func(old, new []byte) {
+		calls++
+		test.That(t, &old[:cap(old)][0] != &new[:cap(new)][0], "buffers must be distinct arrays")
+		test.Bytes(t, old[2:], new[:len(old)-2], "unread bytes must be moved to the new buffer")
+	})
+	test.That(t, z.Peek(7) == 'u', "must be 'u' at position 9")
+	test.That(t, calls == 1, "callback must be called on reallocation")
+
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum dolor")), 16)
+	test.That(t, z.Peek(12) == 'd', "must be 'd' at position 12")
+	z.Move(12)
+	z.Skip()
+	calls = 0
+	z.OnRealloc(func(old, new []byte) {
+		calls++
+	})
+	test.That(t, z.Peek(4) == 'r', "must be 'r' at position 16")
+	test.That(t, z.Cap() == 16, "bytes must be moved within the buffer")
+	test.That(t, calls == 0, "callback must not be called when moving within the buffer")
+}
+
+func TestShifterSizeHint(t *testing.T) {
+	z := NewShifter(strings.NewReader("Lorem ipsum"))
+	test.That(t, z.Cap() == 12, "capacity must fit the length of the reader")
+	z.MoveToEnd()
+	test.Bytes(t, z.Shift(), []byte("Lorem ipsum"), "must read all bytes")
+	_, reallocs, _ := z.Metrics()
+	test.That(t, reallocs == 0, "reading till EOF must not reallocate")
+
+	defer func(n int) { MaxBuf = n }(MaxBuf)
+	MaxBuf = 8
+	z = NewShifter(strings.NewReader("Lorem ipsum"))
+	test.That(t, z.Cap() == 8, "capacity must be clamped to MaxBuf")
+	z = NewShifter(test.NewPlainReader(strings.NewReader("Lorem ipsum")))
+	test.That(t, z.Cap() == defaultBufSize, "capacity must default without a length")
+}
+
+func TestShifterZeroSize(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum")), 0)
+	test.That(t, z.Peek(0) == 'L', "must be 'L' at position 0")
+	test.T(t, z.Err(), nil, "error must be nil")
+	z.MoveToEnd()
+	test.Bytes(t, z.Shift(), []byte("Lorem ipsum"), "must read all bytes")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+}
+
+func TestShifterGrowAtStart(t *testing.T) {
+	s := "Lorem ipsum dolor"
+	z := NewShifterSize(&chunkReader{r: strings.NewReader(s), n: 6}, 8)
+	test.That(t, z.Peek(0) == 'L', "must be 'L' at position 0")
+	test.That(t, len(z.buf) == 6, "must have read the first chunk")
+
+	// without a start offset, a buffer that is more than half full reads into its spare capacity
+	test.That(t, z.Peek(7) == 'p', "must be 'p' at position 7")
+	_, reallocs, _ := z.Metrics()
+	test.That(t, reallocs == 0, "peeking within the capacity must not reallocate")
+	test.That(t, z.Cap() == 8, "capacity must not change")
+
+	test.That(t, z.Peek(14) == 'l', "must be 'l' at position 14")
+	_, reallocs, _ = z.Metrics()
+	test.That(t, reallocs == 1, "peeking beyond the capacity must reallocate")
+	test.That(t, z.Cap() > 8, "capacity must grow")
+	z.Move(15)
+	test.Bytes(t, z.Bytes(), []byte("Lorem ipsum dol"), "bytes must be kept when growing")
+}
+
+func TestShifterFeed(t *testing.T) {
+	z := NewShifter(NewReader(nil))
+	test.That(t, z.Peek(0) == 0, "must be EOF before feeding")
+
+	var tokens []string
+	lex := func() {
+		for {
+			i := 0
+			for z.Peek(i) != ' ' && z.Peek(i) != 0 {
+				i++
+			}
+			if z.Peek(i) == 0 {
+				return // wait for more data
+			}
+			z.Move(i)
+			tokens = append(tokens, string(z.Shift()))
+			z.Move(1)
+			z.Skip()
+		}
+	}
+	for _, chunk := range []string{"Lor", "em ips", "um dolor", " "} {
+		z.Feed([]byte(chunk))
+		lex()
+	}
+	test.T(t, strings.Join(tokens, ","), "Lorem,ipsum,dolor", "tokens must span the fed chunks")
+	test.That(t, z.Peek(0) == 0, "must be EOF after the fed bytes")
+
+	b := []byte("Lorem ipsum")
+	z = NewShifter(NewReader(b[:5]))
+	z.Feed([]byte("!"))
+	test.Bytes(t, b, []byte("Lorem ipsum"), "must not append into the spare capacity of the reader")
+	z.MoveToEnd()
+	test.Bytes(t, z.Bytes(), []byte("Lorem!"), "selection must include the fed bytes")
+
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString(" ipsum")), 4)
+	z.Feed([]byte("Lorem"))
+	z.MoveToEnd()
+	test.Bytes(t, z.Bytes(), []byte(" ipsLoremum"), "fed bytes must be between the buffered bytes and the remainder of the reader")
+}
+
+func TestShifterPushback(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum")), 4)
+	z.Move(2)
+	z.Pushback([]byte("x"))
+	test.That(t, z.Peek(0) == 'x', "must be the pushed back byte first")
+	test.That(t, z.Peek(1) == 'r', "must be the original byte after the pushed back byte")
+	test.Bytes(t, z.Bytes(), []byte("Lo"), "selection must be unaffected")
+	z.Move(1)
+	z.Pushback([]byte("dolor "))
+	z.MoveToEnd()
+	test.Bytes(t, z.Shift(), []byte("Loxdolor rem ipsum"), "pushed back bytes must precede the original stream")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+
+	z.Pushback([]byte("sit"))
+	test.T(t, z.Err(), nil, "error must be nil while pushed back bytes remain")
+	z.Move(3)
+	test.Bytes(t, z.Shift(), []byte("sit"), "must push back after EOF")
+	test.T(t, z.Err(), io.EOF, "error must be EOF after the pushed back bytes")
+
+	z = NewShifter(NewReader([]byte("Lorem")))
+	z.Move(8)
+	z.Pushback([]byte("sit"))
+	test.That(t, z.Peek(0) == 's', "must push back at EOF when moved beyond EOF")
+	z.Move(3)
+	test.Bytes(t, z.Shift(), []byte("Loremsit"), "pushed back bytes must follow the stream when moved beyond EOF")
+}
+
+func TestShifterCompact(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum dolor")), 16)
+	z.Move(6)
+	z.Skip()
+	z.Move(2)
+	c := z.Cap()
+	before := z.Snapshot()
+	z.Compact()
+	test.That(t, z.pos == 0, "start position must be zero")
+	test.That(t, z.Pos() == 2, "end position must be unaffected")
+	test.That(t, z.Snapshot() == before, "stream offsets must be unaffected")
+	test.That(t, z.Cap() == c, "capacity must be unaffected")
+	test.That(t, z.Peek(3) == ' ', "must be ' ' at position 11")
+	test.That(t, z.Peek(8) == 'r', "must be 'r' at position 16")
+	test.That(t, z.Cap() == c, "peeking into the reclaimed space must not reallocate")
+	test.Bytes(t, z.Bytes(), []byte("ip"), "selection must be unaffected")
+
+	z = NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum")), 16)
+	z.Move(6)
+	test.That(t, z.Peek(5) == 0 && z.IsEOF(), "must be EOF at position 11")
+	lorem := z.Shift()
+	z.Compact()
+	test.Bytes(t, lorem, []byte("Lorem "), "compacting at EOF must not overwrite shifted bytes")
+	z.Move(5)
+	test.Bytes(t, z.Shift(), []byte("ipsum"), "must shift the remainder at EOF")
+}
+
+func TestShifterCap(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBuffer(bytes.Repeat([]byte("Lorem ipsum "), 1000))), 8)
+	test.That(t, z.Cap() == 8, "capacity must equal the initial size")
+	z.Peek(8)
+	test.That(t, z.Cap() == 2*8+8, "capacity must grow after a reallocation")
+	z.Peek(5000)
+	test.That(t, z.Cap() > 5000, "capacity must fit the lookahead")
+	z.Move(4998)
+	z.Skip()
+	z.ShrinkBuffer()
+	test.That(t, z.Cap() == defaultBufSize, "capacity must equal the default size after shrinking")
+}
+
+func TestShifterMetrics(t *testing.T) {
+	z := NewShifterSize(&chunkReader{r: bytes.NewBufferString("Lorem ipsum dolor"), n: 4}, 4)
+	reads, reallocs, bytesRead := z.Metrics()
+	test.That(t, reads == 1 && reallocs == 0 && bytesRead == 4, "constructor must read once")
+
+	z.Peek(9)
+	reads, reallocs, bytesRead = z.Metrics()
+	test.That(t, reads == 3 && reallocs == 1 && bytesRead == 12, "peeking must read twice and reallocate once")
+
+	z.MoveTo(12)
+	z.Skip()
+	z.Peek(4)
+	reads, reallocs, bytesRead = z.Metrics()
+	test.That(t, reads == 5 && reallocs == 1 && bytesRead == 17, "reading into the existing buffer must not reallocate")
+
+	z = NewShifter(bytes.NewBufferString("Lorem ipsum"))
+	z.Peek(20)
+	reads, reallocs, bytesRead = z.Metrics()
+	test.That(t, reads == 0 && reallocs == 0 && bytesRead == 0, "in-memory buffer must not read")
+}
+
+func TestShifterPeekBuffered(t *testing.T) {
+	r := &chunkReader{r: bytes.NewBufferString("Lorem ipsum"), n: 4}
+	z := NewShifterSize(r, 8)
+	reads := r.reads
+	c, ok := z.PeekBuffered(3)
+	test.That(t, c == 'e' && ok, "must be 'e' at position 3")
+	c, ok = z.PeekBuffered(4)
+	test.That(t, c == 0 && !ok, "must not be available at position 4")
+	test.That(t, r.reads == reads, "must not read when the byte is not buffered")
+
+	z.Move(1)
+	c, ok = z.PeekBuffered(2)
+	test.That(t, c == 'e' && ok, "must be relative to the end position")
+	test.That(t, z.Peek(3) == 'm', "must be 'm' at position 4 after reading")
+	c, ok = z.PeekBuffered(3)
+	test.That(t, c == 'm' && ok, "must be available after reading")
+}
+
+func TestShifterPeekAt(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum")), 8)
+	z.Move(6)
+	z.Skip()
+	z.Move(2)
+	test.That(t, z.PeekAt(0) == 'i', "first byte of the selection must be 'i'")
+	test.That(t, z.PeekAt(1) == 'p', "second byte of the selection must be 'p'")
+	test.That(t, z.PeekAt(2) == 0, "unbuffered byte must be zero")
+	test.That(t, z.PeekAt(-7) == 0, "byte before the buffer must be zero")
+	test.That(t, z.PeekAt(-1) == ' ', "byte before the selection must be ' ' when buffered")
+}
+
+type growingReader struct {
+	chunks []string
+}
+
+func (r *growingReader) Read(b []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestShifterRetryEOF(t *testing.T) {
+	r := &growingReader{[]string{"Lorem"}}
+	z := NewShifter(r)
+	z.SetRetryEOF(true)
+	test.That(t, z.Peek(4) == 'm', "must be 'm' at position 4")
+	test.That(t, z.Peek(5) == 0, "must yield EOF at position 5")
+	test.That(t, !z.IsEOF(), "must not be EOF when retrying")
+	z.Move(5)
+	test.T(t, z.Err(), io.EOF, "error must be EOF at the end of the available data")
+
+	r.chunks = append(r.chunks, " ipsum")
+	test.That(t, z.Peek(0) == ' ', "must read more data after EOF")
+	test.T(t, z.Err(), nil, "error must be nil after reading more data")
+	z.Move(6)
+	test.Bytes(t, z.Bytes(), []byte("Lorem ipsum"), "selection must span both reads")
+
+	z.SetRetryEOF(false)
+	test.That(t, z.Peek(0) == 0, "must yield EOF")
+	test.That(t, z.IsEOF(), "must be EOF when not retrying")
+	r.chunks = append(r.chunks, " dolor")
+	test.That(t, z.Peek(0) == 0, "must not read after EOF when not retrying")
+}
+
+type emptyReader struct{}
+
+func (r emptyReader) Read(b []byte) (int, error) {
+	return 0, nil
+}
+
+func TestShifterEOFReached(t *testing.T) {
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("Lorem ipsum")), 4)
+	test.That(t, !z.EOFReached(), "source must not be exhausted after the first read")
+	z.Peek(10)
+	test.That(t, !z.EOFReached(), "source must not be exhausted before reading EOF")
+	z.Peek(11)
+	test.That(t, z.EOFReached(), "source must be exhausted after reading EOF")
+	test.T(t, z.Err(), nil, "error must be nil while buffered bytes remain")
+	z.Move(11)
+	test.That(t, z.EOFReached(), "source must be exhausted")
+	test.T(t, z.Err(), io.EOF, "error must be EOF when all bytes are consumed")
+
+	r := &growingReader{[]string{"Lorem"}}
+	z = NewShifter(r)
+	z.SetRetryEOF(true)
+	z.Peek(5)
+	test.That(t, z.EOFReached() && !z.IsEOF(), "source must be exhausted while retrying")
+	r.chunks = append(r.chunks, " ipsum")
+	z.Peek(5)
+	test.That(t, !z.EOFReached(), "source must not be exhausted after reading more data")
+}
+
+func TestShifterEmptyRead(t *testing.T) {
+	z := NewShifter(&growingReader{[]string{"Lo", "", "rem", "", ""}})
+	test.That(t, z.Peek(4) == 'm', "must retry after reading no data")
+	test.T(t, z.Err(), nil, "error must not be a false EOF")
+	test.That(t, z.Peek(5) == 0, "must yield EOF at position 5")
+	z.Move(5)
+	test.T(t, z.Err(), io.EOF, "error must be EOF at the end")
+
+	z = NewShifter(emptyReader{})
+	test.That(t, z.Peek(0) == 0, "must give up when no data is ever read")
+	test.T(t, z.Err(), io.ErrNoProgress, "error must be ErrNoProgress")
+}
+
+type dataEOFReader struct {
+	r io.Reader
+}
+
+func (r *dataEOFReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func TestShifterDataEOF(t *testing.T) {
+	z := NewShifterSize(&dataEOFReader{bytes.NewBufferString("Lorem ipsum")}, 8)
+	test.That(t, z.Peek(7) == 'p', "must be 'p' at position 7")
+	test.That(t, !z.IsEOF(), "must not be EOF when filling the buffer")
+	test.That(t, z.Peek(10) == 'm', "bytes returned with EOF must be peekable")
+	test.That(t, z.IsEOF(), "must be EOF after reading the last bytes")
+	z.Move(10)
+	test.T(t, z.Err(), nil, "error must be nil while bytes remain")
+	z.Move(1)
+	test.T(t, z.Err(), io.EOF, "error must be EOF after the last byte")
+	test.Bytes(t, z.Shift(), []byte("Lorem ipsum"), "no bytes must be dropped")
+}
+
+type errOnceReader struct {
+	r    io.Reader
+	done bool
+}
+
+var errTimeout = errors.New("timeout")
+
+func (r *errOnceReader) Read(b []byte) (int, error) {
+	if !r.done {
+		r.done = true
+		return 0, errTimeout
+	}
+	return r.r.Read(b)
+}
+
+func TestShifterClearErr(t *testing.T) {
+	z := NewShifter(&errOnceReader{r: bytes.NewBufferString("Lorem")})
+	test.That(t, z.Peek(0) == 0, "first character must yield error")
+	test.T(t, z.Err(), errTimeout, "error must be the reader's error")
+
+	z.ClearErr()
+	test.T(t, z.Err(), nil, "error must be nil after clearing")
+	test.That(t, z.Peek(0) == 'L', "first character must be 'L' after clearing")
+	test.That(t, z.Peek(4) == 'm', "fifth character must be 'm' after clearing")
+
+	z.Move(5)
+	test.That(t, z.Peek(0) == 0, "must yield EOF at the end")
+	test.T(t, z.Err(), io.EOF, "error must be EOF at the end")
+	z.ClearErr()
+	test.T(t, z.Err(), io.EOF, "EOF must not be cleared")
+	test.That(t, z.IsEOF(), "EOF must not be cleared")
+}
+
+func TestShifterSection(t *testing.T) {
+	r := bytes.NewReader([]byte("Lorem ipsum dolor sit amet"))
+	sections := []struct {
+		off, length int64
+		words       []string
+	}{
+		{0, 12, []string{"Lorem", "ipsum"}},
+		{12, 14, []string{"dolor", "sit", "amet"}},
+	}
+
+	var wg sync.WaitGroup
+	words := make([][]string, len(sections))
+	for i, section := range sections {
+		wg.Add(1)
+		go func(i int, off, length int64) {
+			defer wg.Done()
+			z := NewShifterSection(r, off, length)
+			for z.Peek(0) != 0 {
+				for c := z.Peek(0); c != ' ' && c != 0; c = z.Peek(0) {
+					z.Move(1)
+				}
+				words[i] = append(words[i], string(z.Shift()))
+				z.Move(1)
+				z.Skip()
+			}
+		}(i, section.off, section.length)
+	}
+	wg.Wait()
+
+	for i, section := range sections {
+		test.T(t, words[i], section.words, "words in section must match")
+	}
+}
+
+type blockingReader struct {
+	r       io.Reader
+	unblock chan struct{}
+}
+
+func (r *blockingReader) Read(b []byte) (int, error) {
+	if n, _ := r.r.Read(b); n > 0 {
+		return n, nil
+	}
+	<-r.unblock
+	return 0, io.EOF
+}
+
+func TestShifterContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &blockingReader{bytes.NewBufferString("Lorem"), make(chan struct{})}
+	defer close(r.unblock)
+
+	z := NewShifterContext(ctx, r)
+	test.That(t, z.Peek(0) == 'L', "first character must be 'L'")
+	test.That(t, z.Peek(4) == 'm', "fifth character must be 'm'")
+	test.T(t, z.Err(), nil, "error must be nil before cancellation")
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	test.That(t, z.Peek(5) == 0, "blocking read must yield error after cancellation")
+	test.T(t, z.Err(), context.Canceled, "error must be context.Canceled")
+	test.That(t, z.Peek(4) == 'm', "buffered bytes must still be peekable")
+}
+
+func TestShifterAcceptRunTable(t *testing.T) {
+	ident := RangeCharset('a', 'z').Or(RangeCharset('A', 'Z')).Or(RangeCharset('0', '9')).Or(MakeCharset("_"))
+
+	s := `lorem_ipsum42 dolor`
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString(s)), 4)
+	test.That(t, z.AcceptRunTable(&ident) == 13, "run must span reads")
+	test.Bytes(t, z.Shift(), []byte("lorem_ipsum42"), "run must select the identifier")
+	test.That(t, z.AcceptRunTable(&ident) == 0, "run must be empty at a space")
+	z.Move(1)
+	z.Skip()
+	test.That(t, z.AcceptRunTable(&ident) == 5, "run must stop at EOF")
+	test.Bytes(t, z.Shift(), []byte("dolor"), "run must select the last identifier")
+	test.T(t, z.Err(), io.EOF, "error must be EOF at the end")
+}
+
+func TestShifterMoveWhile(t *testing.T) {
+	isLetter := func(c byte) bool { return 'a' <= c && c <= 'z' }
+	s := strings.Repeat("x", 2*defaultBufSize) + " lorem"
+	z := NewShifter(test.NewPlainReader(bytes.NewBufferString(s)))
+	test.That(t, z.MoveWhile(isLetter) == 2*defaultBufSize, "run must be longer than the buffer")
+	test.That(t, z.Peek(0) == ' ', "must stop at ' '")
+	test.That(t, z.MoveWhile(isLetter) == 0, "run must be empty at ' '")
+	z.Move(1)
+	z.Skip()
+	test.That(t, z.MoveWhile(isLetter) == 5, "run must stop at EOF")
+	test.Bytes(t, z.Shift(), []byte("lorem"), "selection must be the last run")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+}
+
+func TestShifterScanWhile(t *testing.T) {
+	digits := RangeCharset('0', '9')
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("12345+678")), 4)
+	n, next := z.ScanWhile(&digits)
+	test.That(t, n == 5 && next == '+', "run must end at '+' after a reallocation")
+	n, next = z.ScanWhile(&digits)
+	test.That(t, n == 0 && next == '+', "run must be empty at '+'")
+	z.Move(1)
+	n, next = z.ScanWhile(&digits)
+	test.That(t, n == 3 && next == 0, "run must end at EOF with a zero byte")
+	test.Bytes(t, z.Shift(), []byte("12345+678"), "selection must include the runs")
+	test.T(t, z.Err(), io.EOF, "error must be EOF")
+}
+
+func TestShifterScanUntilAny(t *testing.T) {
+	delims := MakeCharset(" ,;")
+	z := NewShifterSize(test.NewPlainReader(bytes.NewBufferString("lorem_ipsum;dolor,sit amet")), 4)
+	test.That(t, z.ScanUntilAny(&delims) == 11, "must stop at a delimiter after reallocations")
+	test.Bytes(t, z.Shift(), []byte("lorem_ipsum"), "delimiter must not be consumed")
+	test.That(t, z.ScanUntilAny(&delims) == 0, "must not advance at a delimiter")
+	z.Move(1)
+	test.That(t, z.ScanUntilAny(&delims) == 5, "must stop at another delimiter")
+	z.Move(1)
+	test.That(t, z.ScanUntilAny(&delims) == 3, "must stop at the first of several delimiters")
+	test.Bytes(t, z.Shift(), []byte(";dolor,sit"), "selection must include the earlier runs")
+	z.Move(1)
+	test.That(t, z.ScanUntilAny(&delims) == 4, "must stop at EOF without delimiter")
+	test.Bytes(t, z.Shift(), []byte(" amet"), "selection must end at EOF")
+	test.T(t, z.Err(), io.EOF, "error must be EOF at the end")
+}
+
 ////////////////////////////////////////////////////////////////
 
 func ExampleNewShifter() {
@@ -106,7 +1492,7 @@ func ExampleShifter_IsEOF() {
 	z := NewShifter(b)
 	z.Move(5)
 
-	lorem := z.Shift()
+	lorem := z.ShiftNoCopy()
 	if !z.IsEOF() { // required when io.Reader doesn't provide a Bytes function
 		buf := make([]byte, len(lorem))
 		copy(buf, lorem)
@@ -128,6 +1514,79 @@ func BenchmarkPeek(b *testing.B) {
 	}
 }
 
+var _identifiers = bytes.Repeat([]byte("lorem_ipsum dolor42 sit_amet consectetur "), 100)
+
+func isIdentifier(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+func BenchmarkShifterPeekRune(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		z := NewShifter(NewReader(_identifiers))
+		for r, n := z.PeekRune(0); r != 0; r, n = z.PeekRune(0) {
+			z.Move(n)
+		}
+	}
+}
+
+func BenchmarkIdentifierPeek(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		z := NewShifter(NewReader(_identifiers))
+		for z.Peek(0) != 0 {
+			for isIdentifier(z.Peek(0)) {
+				z.Move(1)
+			}
+			z.Move(1)
+			z.Skip()
+		}
+	}
+}
+
+func BenchmarkIdentifierAcceptRunTable(b *testing.B) {
+	ident := RangeCharset('a', 'z').Or(RangeCharset('A', 'Z')).Or(RangeCharset('0', '9')).Or(MakeCharset("_"))
+	for i := 0; i < b.N; i++ {
+		z := NewShifter(NewReader(_identifiers))
+		for z.Peek(0) != 0 {
+			z.AcceptRunTable(&ident)
+			z.Move(1)
+			z.Skip()
+		}
+	}
+}
+
+func BenchmarkShifterResetBytes(b *testing.B) {
+	z := NewShifter(NewReader(nil))
+	buf := []byte("Lorem ipsum")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		z.ResetBytes(buf)
+		z.Move(5)
+		z.Shift()
+	}
+}
+
+func BenchmarkShifterEqual(b *testing.B) {
+	z := NewShifter(bytes.NewBufferString("function"))
+	z.Move(8)
+	keyword := []byte("function")
+	for i := 0; i < b.N; i++ {
+		if z.Equal(keyword) {
+			_c++
+		}
+	}
+}
+
+func BenchmarkShifterBytesEqual(b *testing.B) {
+	z := NewShifter(bytes.NewBufferString("function"))
+	z.Move(8)
+	keyword := []byte("function")
+	for i := 0; i < b.N; i++ {
+		if bytes.Equal(z.Bytes(), keyword) {
+			_c++
+		}
+	}
+}
+
 var _c = 0
 var _haystack = []byte("abcdefghijklmnopqrstuvwxyz")
 
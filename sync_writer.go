@@ -0,0 +1,59 @@
+package buffer // import "github.com/tdewolff/buffer"
+
+import "sync"
+
+// SyncWriter implements an io.Writer over a byte slice that is safe for concurrent use by multiple goroutines.
+// Every call acquires a lock, use Writer instead when writing from a single goroutine as it is considerably faster.
+type SyncWriter struct {
+	mu sync.Mutex
+	w  Writer
+}
+
+// NewSyncWriter returns a new SyncWriter for a given byte slice.
+func NewSyncWriter(buf []byte) *SyncWriter {
+	return &SyncWriter{
+		w: Writer{
+			buf: buf,
+		},
+	}
+}
+
+// Write writes bytes from the given byte slice and returns the number of bytes written and an error if occurred. When err != nil, n == 0.
+func (w *SyncWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	n, err := w.w.Write(b)
+	w.mu.Unlock()
+	return n, err
+}
+
+// WriteString writes the given string and returns the number of bytes written and an error if occurred.
+func (w *SyncWriter) WriteString(s string) (int, error) {
+	w.mu.Lock()
+	n, err := w.w.Write([]byte(s))
+	w.mu.Unlock()
+	return n, err
+}
+
+// Len returns the length of the underlying byte slice.
+func (w *SyncWriter) Len() int {
+	w.mu.Lock()
+	n := w.w.Len()
+	w.mu.Unlock()
+	return n
+}
+
+// Bytes returns a copy of the underlying byte slice, as the underlying byte slice may be modified by concurrent writes.
+func (w *SyncWriter) Bytes() []byte {
+	w.mu.Lock()
+	b := make([]byte, w.w.Len())
+	copy(b, w.w.Bytes())
+	w.mu.Unlock()
+	return b
+}
+
+// Reset empties and reuses the current buffer.
+func (w *SyncWriter) Reset() {
+	w.mu.Lock()
+	w.w.Reset()
+	w.mu.Unlock()
+}
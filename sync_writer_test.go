@@ -0,0 +1,33 @@
+package buffer // import "github.com/tdewolff/buffer"
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestSyncWriter(t *testing.T) {
+	w := NewSyncWriter(make([]byte, 0, 3))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				w.Write([]byte("abc"))
+				w.WriteString("de")
+				w.Bytes()
+			}
+		}()
+	}
+	wg.Wait()
+
+	test.That(t, w.Len() == 16*100*5, "length must equal the sum of all writes")
+	test.That(t, bytes.Count(w.Bytes(), []byte("abc")) == 16*100, "all writes must be intact")
+
+	w.Reset()
+	test.That(t, w.Len() == 0, "reset must empty the buffer")
+}
@@ -1,8 +1,19 @@
 package buffer // import "github.com/tdewolff/buffer"
 
+import (
+	"hash"
+	"strconv"
+	"unicode/utf8"
+)
+
+const hexDigits = "0123456789abcdef"
+
 // Writer implements an io.Writer over a byte slice.
 type Writer struct {
 	buf []byte
+
+	h      hash.Hash
+	hashed int // length of buf written to h
 }
 
 // NewWriter returns a new Writer for a given byte slice.
@@ -12,17 +23,166 @@ func NewWriter(buf []byte) *Writer {
 	}
 }
 
+// NewHashWriter returns a new Writer with an initial buffer size that computes the hash of the written bytes using h, which is returned by Hash.
+func NewHashWriter(h hash.Hash, size int) *Writer {
+	return &Writer{
+		buf: make([]byte, 0, size),
+		h:   h,
+	}
+}
+
 // Write writes bytes from the given byte slice and returns the number of bytes written and an error if occurred. When err != nil, n == 0.
 func (w *Writer) Write(b []byte) (int, error) {
+	end := w.extend(len(b))
+	return copy(w.buf[end:], b), nil
+}
+
+// WriteLower writes bytes from the given byte slice with ASCII uppercase letters converted to lowercase, other bytes are written unchanged.
+func (w *Writer) WriteLower(b []byte) {
+	buf := w.buf[w.extend(len(b)):]
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		buf[i] = c
+	}
+}
+
+// WriteHTMLEscaped writes bytes from the given byte slice with <, >, &, " and ' replaced by the same HTML entities as html.EscapeString. Runs of other bytes are copied at once.
+func (w *Writer) WriteHTMLEscaped(b []byte) {
+	start := 0
+	for i, c := range b {
+		var entity string
+		switch c {
+		case '<':
+			entity = "&lt;"
+		case '>':
+			entity = "&gt;"
+		case '&':
+			entity = "&amp;"
+		case '"':
+			entity = "&#34;"
+		case '\'':
+			entity = "&#39;"
+		default:
+			continue
+		}
+		w.Write(b[start:i])
+		w.buf = append(w.buf, entity...)
+		start = i + 1
+	}
+	w.Write(b[start:])
+}
+
+// CountHTMLEscaped returns the length of b after escaping by WriteHTMLEscaped, which allows sizing the buffer passed to NewWriter before escaping large inputs.
+func CountHTMLEscaped(b []byte) int {
 	n := len(b)
+	for _, c := range b {
+		switch c {
+		case '<', '>':
+			n += 3
+		case '&', '"', '\'':
+			n += 4
+		}
+	}
+	return n
+}
+
+// extend extends the buffer by n bytes and returns the previous length.
+func (w *Writer) extend(n int) int {
 	end := len(w.buf)
 	if end+n > cap(w.buf) {
-		buf := make([]byte, end, 2*cap(w.buf)+n)
+		buf := make([]byte, end, grow(cap(w.buf))+n)
 		copy(buf, w.buf)
 		w.buf = buf
 	}
 	w.buf = w.buf[:end+n]
-	return copy(w.buf[end:], b), nil
+	return end
+}
+
+// AppendInt writes the string form of the integer i in the given base, see strconv.AppendInt.
+func (w *Writer) AppendInt(i int64, base int) {
+	w.buf = strconv.AppendInt(w.buf, i, base)
+}
+
+// AppendFloat writes the string form of the floating-point number f, see strconv.AppendFloat.
+func (w *Writer) AppendFloat(f float64, fmt byte, prec, bitSize int) {
+	w.buf = strconv.AppendFloat(w.buf, f, fmt, prec, bitSize)
+}
+
+// WriteUintPadded writes the unsigned integer v in the given base (2 to 36), left-padded with pad to at least width bytes.
+func (w *Writer) WriteUintPadded(v uint64, base, width int, pad byte) {
+	var a [64]byte
+	b := strconv.AppendUint(a[:0], v, base)
+	n := width - len(b)
+	if n < 0 {
+		n = 0
+	}
+	buf := w.buf[w.extend(n+len(b)):]
+	for i := 0; i < n; i++ {
+		buf[i] = pad
+	}
+	copy(buf[n:], b)
+}
+
+// AppendQuote writes s as a double-quoted string with Go escape sequences, following the rules of strconv.AppendQuote.
+func (w *Writer) AppendQuote(s []byte) {
+	w.appendQuote(s, '"')
+}
+
+// AppendQuoteSingle writes s as a single-quoted string with Go escape sequences, like AppendQuote but escaping single instead of double quotes.
+func (w *Writer) AppendQuoteSingle(s []byte) {
+	w.appendQuote(s, '\'')
+}
+
+func (w *Writer) appendQuote(s []byte, quote byte) {
+	buf := append(w.buf, quote)
+	for len(s) > 0 {
+		r, n := rune(s[0]), 1
+		if r >= utf8.RuneSelf {
+			r, n = utf8.DecodeRune(s)
+		}
+		if n == 1 && r == utf8.RuneError {
+			buf = append(buf, '\\', 'x', hexDigits[s[0]>>4], hexDigits[s[0]&0xF])
+		} else if r == rune(quote) || r == '\\' {
+			buf = append(buf, '\\', byte(r))
+		} else if strconv.IsPrint(r) {
+			buf = append(buf, s[:n]...)
+		} else {
+			switch r {
+			case '\a':
+				buf = append(buf, '\\', 'a')
+			case '\b':
+				buf = append(buf, '\\', 'b')
+			case '\f':
+				buf = append(buf, '\\', 'f')
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			case '\v':
+				buf = append(buf, '\\', 'v')
+			default:
+				if r < ' ' || r == 0x7F {
+					buf = append(buf, '\\', 'x', hexDigits[r>>4], hexDigits[r&0xF])
+				} else if r < 0x10000 {
+					buf = append(buf, '\\', 'u')
+					for i := 12; i >= 0; i -= 4 {
+						buf = append(buf, hexDigits[r>>uint(i)&0xF])
+					}
+				} else {
+					buf = append(buf, '\\', 'U')
+					for i := 28; i >= 0; i -= 4 {
+						buf = append(buf, hexDigits[r>>uint(i)&0xF])
+					}
+				}
+			}
+		}
+		s = s[n:]
+	}
+	w.buf = append(buf, quote)
 }
 
 // Len returns the length of the underlying byte slice.
@@ -30,6 +190,23 @@ func (w *Writer) Len() int {
 	return len(w.buf)
 }
 
+// Pos returns a mark to which can be rewinded, it equals Len.
+func (w *Writer) Pos() int {
+	return len(w.buf)
+}
+
+// Rewind discards all bytes written after the given position, previously obtained from Pos. It panics if pos is out of range.
+func (w *Writer) Rewind(pos int) {
+	if pos < 0 || pos > len(w.buf) {
+		panic("buffer: rewind out of range")
+	}
+	if pos < w.hashed {
+		w.h.Reset()
+		w.hashed = 0
+	}
+	w.buf = w.buf[:pos]
+}
+
 // Bytes returns the underlying byte slice.
 func (w *Writer) Bytes() []byte {
 	return w.buf
@@ -38,4 +215,17 @@ func (w *Writer) Bytes() []byte {
 // Reset empties and reuses the current buffer. Subsequent writes will overwrite the buffer, so any reference to the underlying slice is invalidated after this call.
 func (w *Writer) Reset() {
 	w.buf = w.buf[:0]
+	if w.h != nil {
+		w.h.Reset()
+		w.hashed = 0
+	}
+}
+
+// Hash returns the hash of all written bytes for a Writer created by NewHashWriter, or nil otherwise. The hash is updated with the bytes written since the previous call, so that writing has no overhead, and it accounts for Rewind and Reset.
+func (w *Writer) Hash() hash.Hash {
+	if w.h != nil {
+		w.h.Write(w.buf[w.hashed:])
+		w.hashed = len(w.buf)
+	}
+	return w.h
 }
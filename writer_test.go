@@ -1,7 +1,12 @@
 package buffer // import "github.com/tdewolff/buffer"
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"html"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/tdewolff/test"
@@ -29,6 +34,146 @@ func TestWriter(t *testing.T) {
 	test.Bytes(t, w.Bytes(), []byte("ghijkl"), "third write must match 'ghijkl'")
 }
 
+func TestWriterRewind(t *testing.T) {
+	w := NewWriter(make([]byte, 0, 3))
+	w.Write([]byte("abc"))
+	pos := w.Pos()
+	test.That(t, pos == 3, "position must equal length")
+
+	w.Write([]byte("def"))
+	test.Bytes(t, w.Bytes(), []byte("abcdef"), "write after checkpoint must match 'abcdef'")
+	w.Rewind(pos)
+	test.Bytes(t, w.Bytes(), []byte("abc"), "rewind must discard bytes after checkpoint")
+	test.That(t, w.Pos() == 3, "position must equal checkpoint after rewind")
+
+	w.Write([]byte("ghi"))
+	test.Bytes(t, w.Bytes(), []byte("abcghi"), "write after rewind must match 'abcghi'")
+
+	defer func() {
+		test.That(t, recover() != nil, "rewind beyond length must panic")
+	}()
+	w.Rewind(7)
+}
+
+func TestWriterHTMLEscaped(t *testing.T) {
+	var escapeTests = []struct {
+		s        string
+		expected string
+	}{
+		{"", ""},
+		{"lorem ipsum", "lorem ipsum"},
+		{"<", "&lt;"},
+		{">", "&gt;"},
+		{"&", "&amp;"},
+		{`"`, "&#34;"},
+		{"'", "&#39;"},
+		{`<a href="x">Tom & Jerry's</a>`, "&lt;a href=&#34;x&#34;&gt;Tom &amp; Jerry&#39;s&lt;/a&gt;"},
+	}
+	for _, tt := range escapeTests {
+		w := NewWriter(make([]byte, 0, 2))
+		w.WriteHTMLEscaped([]byte(tt.s))
+		test.T(t, string(w.Bytes()), tt.expected, "escaped string must match for", tt.s)
+		test.T(t, string(w.Bytes()), html.EscapeString(tt.s), "escaped string must match html.EscapeString for", tt.s)
+	}
+}
+
+func TestCountHTMLEscaped(t *testing.T) {
+	var countTests = []string{
+		"",
+		"lorem ipsum",
+		"<>&\"'",
+		`<a href="x">Tom & Jerry's</a>`,
+		strings.Repeat("lorem <ipsum> ", 100),
+	}
+	for _, s := range countTests {
+		w := NewWriter(make([]byte, 0, CountHTMLEscaped([]byte(s))))
+		c := cap(w.Bytes())
+		w.WriteHTMLEscaped([]byte(s))
+		test.That(t, w.Len() == c, "count must equal the escaped length for", s)
+		test.That(t, cap(w.Bytes()) == c, "escaping must not reallocate for", s)
+	}
+}
+
+func TestHashWriter(t *testing.T) {
+	w := NewHashWriter(sha256.New(), 4)
+	w.Write([]byte("lorem "))
+	test.That(t, w.Hash() != nil, "hash must not be nil")
+	w.AppendInt(42, 10)
+	w.WriteLower([]byte(" IPSUM"))
+	expected := sha256.Sum256(w.Bytes())
+	test.Bytes(t, w.Hash().Sum(nil), expected[:], "hash must match the hash of the written bytes")
+
+	w.Rewind(6)
+	w.Write([]byte("dolor"))
+	expected = sha256.Sum256([]byte("lorem dolor"))
+	test.Bytes(t, w.Hash().Sum(nil), expected[:], "hash must exclude rewinded bytes")
+
+	w.Reset()
+	w.Write([]byte("sit"))
+	expected = sha256.Sum256([]byte("sit"))
+	test.Bytes(t, w.Hash().Sum(nil), expected[:], "hash must be reset")
+
+	test.That(t, NewWriter(nil).Hash() == nil, "hash must be nil for a plain Writer")
+}
+
+func TestWriterAppendNumber(t *testing.T) {
+	w := NewWriter(make([]byte, 0, 3))
+	w.AppendInt(-42, 10)
+	w.Write([]byte(" "))
+	w.AppendInt(255, 16)
+	w.Write([]byte(" "))
+	w.AppendFloat(3.25, 'f', -1, 64)
+	w.Write([]byte(" "))
+	w.AppendFloat(1e21, 'g', -1, 64)
+	w.Write([]byte(" "))
+	w.AppendFloat(0.1, 'e', 3, 32)
+	test.Bytes(t, w.Bytes(), []byte("-42 ff 3.25 1e+21 1.000e-01"), "numbers must match strconv formatting")
+}
+
+func TestWriterUintPadded(t *testing.T) {
+	w := NewWriter(make([]byte, 0, 3))
+	w.WriteUintPadded(42, 10, 5, '0')
+	w.Write([]byte(" "))
+	w.WriteUintPadded(123456, 10, 3, '0')
+	w.Write([]byte(" "))
+	w.WriteUintPadded(0xBEEF, 16, 8, '0')
+	w.Write([]byte(" "))
+	w.WriteUintPadded(5, 2, 4, ' ')
+	w.Write([]byte(" "))
+	w.WriteUintPadded(35, 36, 0, '0')
+	test.Bytes(t, w.Bytes(), []byte("00042 123456 0000beef  101 z"), "padded integers must match")
+}
+
+func TestWriterAppendQuote(t *testing.T) {
+	var quoteTests = []string{
+		"",
+		"Lorem ipsum",
+		`say "hi"`,
+		`it's`,
+		`back\slash`,
+		"\a\b\f\n\r\t\v\x00\x1f\x7f",
+		"aæ†\U00100000",
+		"\u00ad\u2028\U000e0001",
+		"invalid \xff\xc3",
+	}
+	for _, s := range quoteTests {
+		w := NewWriter(nil)
+		w.AppendQuote([]byte(s))
+		test.T(t, string(w.Bytes()), strconv.Quote(s), "quoted string must match strconv.Quote")
+	}
+
+	w := NewWriter(nil)
+	w.AppendQuoteSingle([]byte(`it's "ok"\` + "\n"))
+	test.Bytes(t, w.Bytes(), []byte(`'it\'s "ok"\\\n'`), "single quotes must be escaped instead of double quotes")
+}
+
+func TestWriterLower(t *testing.T) {
+	w := NewWriter(make([]byte, 0, 3))
+	w.Write([]byte("AB"))
+	w.WriteLower([]byte("Lorem IPSUM@[`{ ÆØÅ\xC3\x86"))
+	test.Bytes(t, w.Bytes(), []byte("ABlorem ipsum@[`{ ÆØÅ\xC3\x86"), "ASCII letters must be lowercased")
+}
+
 func ExampleNewWriter() {
 	w := NewWriter(make([]byte, 0, 11)) // initial buffer length is 11
 	w.Write([]byte("Lorem ipsum"))
@@ -36,6 +181,91 @@ func ExampleNewWriter() {
 	// Output: Lorem ipsum
 }
 
+func BenchmarkWriterAppendInt(b *testing.B) {
+	w := NewWriter(make([]byte, 0, 64))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		w.AppendInt(int64(i), 10)
+	}
+}
+
+func BenchmarkWriterFormatInt(b *testing.B) {
+	w := NewWriter(make([]byte, 0, 64))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		w.Write([]byte(strconv.FormatInt(int64(i), 10)))
+	}
+}
+
+func BenchmarkWriterAppendFloat(b *testing.B) {
+	w := NewWriter(make([]byte, 0, 64))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		w.AppendFloat(float64(i)/3.0, 'g', -1, 64)
+	}
+}
+
+func BenchmarkWriterLower(b *testing.B) {
+	s := []byte("<DIV CLASS=\"Lorem\">Ipsum</DIV>")
+	w := NewWriter(make([]byte, 0, 64))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		w.WriteLower(s)
+	}
+}
+
+func BenchmarkWriterHTMLEscaped(b *testing.B) {
+	s := []byte(`<a href="x">Tom & Jerry's</a>`)
+	w := NewWriter(make([]byte, 0, 64))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		w.WriteHTMLEscaped(s)
+	}
+}
+
+func BenchmarkWriterHTMLEscapeString(b *testing.B) {
+	s := `<a href="x">Tom & Jerry's</a>`
+	w := NewWriter(make([]byte, 0, 64))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		w.Write([]byte(html.EscapeString(s)))
+	}
+}
+
+func BenchmarkWriterToLower(b *testing.B) {
+	s := []byte("<DIV CLASS=\"Lorem\">Ipsum</DIV>")
+	w := NewWriter(make([]byte, 0, 64))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		w.Write(bytes.ToLower(s))
+	}
+}
+
+func BenchmarkWriterUintPadded(b *testing.B) {
+	w := NewWriter(make([]byte, 0, 64))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		w.WriteUintPadded(uint64(i), 16, 8, '0')
+	}
+}
+
+func BenchmarkWriterFprintf(b *testing.B) {
+	w := NewWriter(make([]byte, 0, 64))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		fmt.Fprintf(w, "%08x", uint64(i))
+	}
+}
+
 func ExampleWriter_Reset() {
 	w := NewWriter(make([]byte, 0, 11))                 // initial buffer length is 10
 	w.Write([]byte("garbage that will be overwritten")) // does reallocation